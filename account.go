@@ -0,0 +1,146 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"github.com/go-ldap/ldap/v3"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The checks of the account state, named after the attribute they read.
+const (
+	// Active Directory, the ACCOUNTDISABLE and LOCKOUT flags
+	accountCheckUserAccountControl = "userAccountControl"
+	// Active Directory, the expiry of the account
+	accountCheckAccountExpires = "accountExpires"
+	// shadowAccount, the days since the epoch after which the account is disabled
+	accountCheckShadowExpire = "shadowExpire"
+	// 389 Directory Server and Oracle Directory Server
+	accountCheckNsAccountLock = "nsAccountLock"
+	// OpenLDAP ppolicy overlay, set while the account is locked
+	accountCheckPwdAccountLockedTime = "pwdAccountLockedTime"
+)
+
+var accountChecks = map[string]bool{
+	accountCheckUserAccountControl:   true,
+	accountCheckAccountExpires:       true,
+	accountCheckShadowExpire:         true,
+	accountCheckNsAccountLock:        true,
+	accountCheckPwdAccountLockedTime: true,
+}
+
+const (
+	uacAccountDisable = 0x2
+	uacLockout        = 0x10
+
+	// the number of 100-nanosecond intervals between 1601-01-01 and 1970-01-01
+	fileTimeUnixEpoch = 116444736000000000
+	fileTimeNever     = 1<<63 - 1
+)
+
+// denyRule denies the accounts having one of the values in the attribute,
+// or having the attribute at all if no value is given.
+type denyRule struct {
+	attribute string
+	values    []string
+}
+
+// fileTime converts an Active Directory timestamp to a time.
+func fileTime(v int64) time.Time {
+	v -= fileTimeUnixEpoch
+	return time.Unix(v/1e7, v%1e7*100)
+}
+
+// checkAccount checks the state of the account from the attributes of its entry.
+// It returns the reason why the account can't be used, or an empty string if it can.
+func checkAccount(conf *config, entry *ldap.Entry, now time.Time) string {
+	for _, check := range conf.accountChecks {
+		value := entry.GetEqualFoldAttributeValue(check)
+		if value == "" {
+			continue
+		}
+
+		switch check {
+		case accountCheckUserAccountControl:
+			uac, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			if uac&uacAccountDisable != 0 {
+				return reasonAccountDisabled
+			}
+			if uac&uacLockout != 0 {
+				return reasonAccountLocked
+			}
+		case accountCheckAccountExpires:
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || v == 0 || v == fileTimeNever {
+				continue
+			}
+			if now.After(fileTime(v)) {
+				return reasonAccountExpired
+			}
+		case accountCheckShadowExpire:
+			days, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || days < 0 {
+				continue
+			}
+			if !now.Before(time.Unix(days*24*60*60, 0)) {
+				return reasonAccountExpired
+			}
+		case accountCheckNsAccountLock:
+			if strings.EqualFold(value, "true") {
+				return reasonAccountDisabled
+			}
+		case accountCheckPwdAccountLockedTime:
+			// the lockout may end after pwdLockoutDuration, which is not known here
+			return reasonAccountLocked
+		}
+	}
+
+	for _, rule := range conf.denyRules {
+		values := entry.GetEqualFoldAttributeValues(rule.attribute)
+		if len(values) == 0 {
+			continue
+		}
+		if len(rule.values) == 0 {
+			return reasonAccountDenied
+		}
+		for _, value := range values {
+			if containsFold(rule.values, value) {
+				return reasonAccountDenied
+			}
+		}
+	}
+	return ""
+}
+
+// checkAccountState checks the state of the account of the user before binding as the user.
+func (f *filter) checkAccountState(conf *config, entry *ldap.Entry) bool {
+	reason := checkAccount(conf, entry, time.Now())
+	if reason == "" {
+		return true
+	}
+	f.callbacks.Log(api.Debug, fmt.Sprintf("account %s can't be used: %s", entry.DN, reason))
+	f.reason = reason
+	return false
+}
@@ -0,0 +1,225 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	aclAllow = "allow"
+	aclDeny  = "deny"
+)
+
+// aclRule maps the requests matching its methods and path to the users allowed to access.
+type aclRule struct {
+	// methods matched, any method if empty
+	methods    []string
+	pathPrefix string
+	pathRegex  *regexp.Regexp
+	pathGlob   string
+	// groups and users allowed to access, any authenticated user if both are empty
+	groups []string
+	users  []string
+	// windows the users are allowed to access in, at any time if empty
+	timeWindows []*timeWindow
+}
+
+// accessControl is an ordered table of rules, the first rule matching the request decides.
+type accessControl struct {
+	rules         []*aclRule
+	defaultAction string
+}
+
+// parseAccessControl parses the accessControl option.
+func parseAccessControl(v interface{}) (*accessControl, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid accessControl: should be an object")
+	}
+	acl := &accessControl{defaultAction: aclDeny}
+	if action, ok := m["defaultAction"].(string); ok && action != "" {
+		if action != aclAllow && action != aclDeny {
+			return nil, fmt.Errorf("invalid accessControl: unknown defaultAction %s", action)
+		}
+		acl.defaultAction = action
+	}
+	list, ok := m["rules"].([]interface{})
+	if !ok {
+		return nil, errors.New("invalid accessControl: rules should be a list")
+	}
+	for i, item := range list {
+		rm, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid accessControl: rule %d should be an object", i)
+		}
+		rule, err := parseACLRule(rm)
+		if err != nil {
+			return nil, fmt.Errorf("invalid accessControl: rule %d: %v", i, err)
+		}
+		acl.rules = append(acl.rules, rule)
+	}
+	return acl, nil
+}
+
+func parseACLRule(m map[string]interface{}) (*aclRule, error) {
+	rule := &aclRule{}
+	var err error
+	for key, list := range map[string]*[]string{"methods": &rule.methods, "groups": &rule.groups, "users": &rule.users} {
+		if v, ok := m[key]; ok {
+			if *list, err = toStringSlice(v); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", key, err)
+			}
+		}
+	}
+	if v, ok := m["timeWindows"]; ok {
+		if rule.timeWindows, err = parseTimeWindows(v); err != nil {
+			return nil, err
+		}
+	}
+	rule.pathPrefix, _ = m["pathPrefix"].(string)
+	rule.pathGlob, _ = m["pathGlob"].(string)
+	if pathRegex, ok := m["pathRegex"].(string); ok && pathRegex != "" {
+		if rule.pathRegex, err = regexp.Compile(pathRegex); err != nil {
+			return nil, fmt.Errorf("invalid pathRegex: %v", err)
+		}
+	}
+	if rule.pathGlob != "" {
+		if _, err := path.Match(rule.pathGlob, "/"); err != nil {
+			return nil, fmt.Errorf("invalid pathGlob: %v", err)
+		}
+	}
+	paths := 0
+	for _, set := range []bool{rule.pathPrefix != "", rule.pathRegex != nil, rule.pathGlob != ""} {
+		if set {
+			paths++
+		}
+	}
+	if paths > 1 {
+		return nil, errors.New("only one of pathPrefix, pathRegex and pathGlob can be set")
+	}
+	return rule, nil
+}
+
+// matches returns true if the rule applies to the request.
+func (r *aclRule) matches(method, reqPath string) bool {
+	if len(r.methods) > 0 && !containsFold(r.methods, method) {
+		return false
+	}
+	switch {
+	case r.pathPrefix != "":
+		return strings.HasPrefix(reqPath, r.pathPrefix)
+	case r.pathRegex != nil:
+		return r.pathRegex.MatchString(reqPath)
+	case r.pathGlob != "":
+		ok, _ := path.Match(r.pathGlob, reqPath)
+		return ok
+	}
+	return true
+}
+
+// allows returns true if the user is allowed by the rule.
+func (r *aclRule) allows(username string, user *ldapUser) bool {
+	if len(r.groups) == 0 && len(r.users) == 0 {
+		return true
+	}
+	if containsFold(r.users, username) || (user != nil && containsFold(r.users, user.name())) {
+		return true
+	}
+	return user != nil && user.memberOf(r.groups)
+}
+
+// usesGroups returns true if a rule allows groups, which are then fetched with the user entry.
+func (acl *accessControl) usesGroups() bool {
+	if acl == nil {
+		return false
+	}
+	for _, rule := range acl.rules {
+		if len(rule.groups) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePath normalizes the path of the request, without its query, the way most upstreams
+// resolve it, so that e.g. //admin/x, /./admin/x and /%61dmin/x can't bypass a rule of /admin:
+// the unreserved characters are percent-decoded, the slashes merged and the dot segments removed.
+func normalizePath(reqPath string) string {
+	var b strings.Builder
+	for i := 0; i < len(reqPath); i++ {
+		if reqPath[i] == '%' && i+2 < len(reqPath) {
+			if c, err := strconv.ParseUint(reqPath[i+1:i+3], 16, 8); err == nil && isUnreserved(byte(c)) {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(reqPath[i])
+	}
+	decoded := b.String()
+	cleaned := path.Clean("/" + decoded)
+	// the trailing slash is significant for the prefixes, e.g. /api/
+	if cleaned != "/" && strings.HasSuffix(decoded, "/") {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// isUnreserved returns true if the character is unreserved in URIs (RFC 3986).
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// checkAccessControl checks the authenticated user against the first rule of the accessControl matching the request.
+func (f *filter) checkAccessControl(header api.RequestHeaderMap, user *ldapUser) *denial {
+	acl := f.config.accessControl
+	if acl == nil {
+		return nil
+	}
+	method := header.Method()
+	reqPath, _, _ := strings.Cut(header.Path(), "?")
+	reqPath = normalizePath(reqPath)
+	for i, rule := range acl.rules {
+		if !rule.matches(method, reqPath) {
+			continue
+		}
+		if rule.allows(f.username, user) {
+			if len(rule.timeWindows) > 0 && !inTimeWindows(rule.timeWindows, time.Now()) {
+				f.callbacks.Log(api.Info, fmt.Sprintf("user %s is outside the time windows of the access control rule %d", user.name(), i))
+				return f.deny(reasonTimeWindow, "access denied outside the allowed hours")
+			}
+			return nil
+		}
+		f.callbacks.Log(api.Debug, fmt.Sprintf("user %s denied by the access control rule %d", user.name(), i))
+		return f.deny(reasonAccessDenied, "access denied")
+	}
+	if acl.defaultAction == aclAllow {
+		return nil
+	}
+	f.callbacks.Log(api.Debug, fmt.Sprintf("no access control rule matches %s %s", method, reqPath))
+	return f.deny(reasonAccessDenied, "access denied")
+}
@@ -0,0 +1,167 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// testCallbacks are the filter callbacks of the tests, only Log is implemented.
+type testCallbacks struct {
+	api.FilterCallbackHandler
+}
+
+func (c *testCallbacks) Log(level api.LogType, msg string) {}
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"/admin/x", "/admin/x"},
+		{"/api/", "/api/"},
+		{"//admin/x", "/admin/x"},
+		{"/admin//x", "/admin/x"},
+		{"/./admin/x", "/admin/x"},
+		{"/public/../admin/x", "/admin/x"},
+		{"/../../admin", "/admin"},
+		{"/%61dmin/x", "/admin/x"},
+		{"/%2e/admin", "/admin"},
+		{"/%2E%2E/admin", "/admin"},
+		{"/a%2Fb", "/a%2Fb"},
+		{"/a%20b", "/a%20b"},
+		{"/a%zzb", "/a%zzb"},
+		{"/a%6", "/a%6"},
+		{"admin", "/admin"},
+	}
+	for _, tt := range tests {
+		if got := normalizePath(tt.path); got != tt.want {
+			t.Errorf("normalizePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestACLRuleMatches(t *testing.T) {
+	parse := func(m map[string]interface{}) *aclRule {
+		rule, err := parseACLRule(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rule
+	}
+	tests := []struct {
+		name   string
+		rule   *aclRule
+		method string
+		path   string
+		want   bool
+	}{
+		{"any", parse(map[string]interface{}{}), "DELETE", "/x", true},
+		{"method", parse(map[string]interface{}{"methods": []interface{}{"GET", "HEAD"}}), "head", "/x", true},
+		{"other method", parse(map[string]interface{}{"methods": []interface{}{"GET"}}), "POST", "/x", false},
+		{"prefix", parse(map[string]interface{}{"pathPrefix": "/api/"}), "GET", "/api/items", true},
+		{"other prefix", parse(map[string]interface{}{"pathPrefix": "/api/"}), "GET", "/apis", false},
+		{"regex", parse(map[string]interface{}{"pathRegex": "^/admin(/.*)?$"}), "GET", "/admin", true},
+		{"other regex", parse(map[string]interface{}{"pathRegex": "^/admin(/.*)?$"}), "GET", "/administrator", false},
+		{"glob", parse(map[string]interface{}{"pathGlob": "/reports/*/export"}), "GET", "/reports/2024/export", true},
+		{"glob across segments", parse(map[string]interface{}{"pathGlob": "/reports/*/export"}), "GET", "/reports/a/b/export", false},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.matches(tt.method, tt.path); got != tt.want {
+			t.Errorf("%s: matches(%s, %s) = %v, want %v", tt.name, tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	for _, m := range []map[string]interface{}{
+		{"pathRegex": "("},
+		{"pathGlob": "[a"},
+		{"pathPrefix": "/a", "pathGlob": "/b"},
+		{"methods": "GET"},
+		{"timeWindows": []interface{}{map[string]interface{}{"start": "25:00"}}},
+	} {
+		if _, err := parseACLRule(m); err == nil {
+			t.Errorf("%v: expected an error", m)
+		}
+	}
+}
+
+func TestCheckAccessControl(t *testing.T) {
+	acl, err := parseAccessControl(map[string]interface{}{
+		"defaultAction": "allow",
+		"rules": []interface{}{
+			map[string]interface{}{"methods": []interface{}{"GET"}, "pathPrefix": "/public/"},
+			map[string]interface{}{
+				"pathPrefix": "/admin",
+				"groups":     []interface{}{"cn=admins,ou=groups,dc=example,dc=com"},
+				"users":      []interface{}{"root"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := &ldapUser{
+		dn:      "cn=alice,dc=example,dc=com",
+		backend: &config{},
+		entry: ldap.NewEntry("cn=alice,dc=example,dc=com", map[string][]string{
+			"memberOf": {"CN=Admins,OU=Groups,DC=example,DC=com"},
+		}),
+	}
+	other := &ldapUser{dn: "cn=bob,dc=example,dc=com", backend: &config{}}
+
+	tests := []struct {
+		username string
+		user     *ldapUser
+		method   string
+		path     string
+		allowed  bool
+	}{
+		{"alice", admin, "GET", "/admin/x", true},
+		{"bob", other, "GET", "/admin/x", false},
+		{"root", other, "GET", "/admin/x", true},
+		{"bob", other, "GET", "/admin/x?y=1", false},
+		{"bob", other, "GET", "//admin/x", false},
+		{"bob", other, "GET", "/./admin/x", false},
+		{"bob", other, "GET", "/%61dmin/x", false},
+		{"bob", other, "GET", "/public/../admin/x", false},
+		{"bob", other, "GET", "/public/page", true},
+		{"bob", other, "POST", "/public/page", true},
+		{"bob", other, "GET", "/other", true},
+	}
+	for _, tt := range tests {
+		f := &filter{callbacks: &testCallbacks{}, config: &config{accessControl: acl}, username: tt.username}
+		d := f.checkAccessControl(newTestHeader(tt.method, tt.path), tt.user)
+		if (d == nil) != tt.allowed {
+			t.Errorf("%s %s %s: allowed = %v, want %v", tt.username, tt.method, tt.path, d == nil, tt.allowed)
+		}
+		if d != nil && (d.status != 403 || d.reason != reasonAccessDenied) {
+			t.Errorf("%s %s %s: unexpected denial %d %s", tt.username, tt.method, tt.path, d.status, d.reason)
+		}
+	}
+
+	acl.defaultAction = aclDeny
+	f := &filter{callbacks: &testCallbacks{}, config: &config{accessControl: acl}, username: "bob"}
+	if f.checkAccessControl(newTestHeader("GET", "/other"), other) == nil {
+		t.Errorf("the requests matching no rule should be denied by default")
+	}
+}
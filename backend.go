@@ -0,0 +1,230 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// The policies to authenticate the user against the chained backends.
+const (
+	// the first backend which identifies the user authenticates it
+	backendPolicyFirstMatch = "firstMatch"
+	// the user must be identified by exactly one backend
+	backendPolicyExactlyOne = "exactlyOne"
+)
+
+// authResult is the result of the authentication against an LDAP backend.
+type authResult int
+
+const (
+	// the backend does not know the user
+	authUnidentified authResult = iota
+	// the backend identified the user, but rejected it
+	authDenied
+	// the backend authenticated the user
+	authSucceeded
+	// the backend could not tell, e.g. it is unreachable
+	authFailed
+)
+
+func (r authResult) String() string {
+	switch r {
+	case authUnidentified:
+		return "unidentified"
+	case authDenied:
+		return "denied"
+	case authSucceeded:
+		return "succeeded"
+	default:
+		return "failed"
+	}
+}
+
+// ldapUser is a user authenticated by an LDAP backend.
+type ldapUser struct {
+	dn string
+	// identity reported by the server with the "Who am I?" operation, empty if not asked
+	identity string
+	// entry of the user with the fetched attributes, nil if it was not looked up
+	entry *ldap.Entry
+	// config of the backend which authenticated the user, nil for a local user
+	backend *config
+	// source which authenticated the user, ldap or local
+	source string
+}
+
+// name returns the canonical identity of the user, used in the logs and the headers.
+func (u *ldapUser) name() string {
+	if u.identity != "" {
+		return u.identity
+	}
+	return u.dn
+}
+
+// groups returns the groups of the user listed in the group attribute of its entry.
+func (u *ldapUser) groups(conf *config) []string {
+	if u.entry == nil {
+		return nil
+	}
+	return u.entry.GetEqualFoldAttributeValues(conf.groupAttr())
+}
+
+// memberOf returns true if the user is member of one of the groups.
+func (u *ldapUser) memberOf(groups []string) bool {
+	for _, group := range u.groups(u.backend) {
+		for _, g := range groups {
+			if equalDN(group, g) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authBackends authenticates the user against the chained backends according to the backendPolicy.
+func (f *filter) authBackends(username, password string) (*ldapUser, authResult) {
+	exactlyOne := f.config.backendPolicy == backendPolicyExactlyOne
+
+	var authenticated *ldapUser
+	var deniedReason string
+	identified, failed := 0, 0
+	for i, backend := range f.config.backends {
+		// the reason is the one of the current backend
+		f.reason = ""
+		user, result := f.authBackend(backend, username, password)
+		f.callbacks.Log(api.Debug, fmt.Sprintf("authentication against backend %d %s", i, result))
+		switch result {
+		case authSucceeded:
+			if !exactlyOne {
+				return user, result
+			}
+			authenticated = user
+			identified++
+		case authDenied:
+			if !exactlyOne {
+				return nil, result
+			}
+			deniedReason = f.reason
+			identified++
+		case authFailed:
+			// the user may exist in the failed backend as well
+			if exactlyOne {
+				return nil, result
+			}
+			failed++
+		}
+	}
+
+	switch {
+	case identified > 1:
+		f.callbacks.Log(api.Debug, fmt.Sprintf("user %s identified by %d backends", username, identified))
+		f.reason = reasonAmbiguousUser
+		return nil, authDenied
+	case authenticated != nil:
+		f.reason = ""
+		return authenticated, authSucceeded
+	case identified == 1:
+		f.reason = deniedReason
+		return nil, authDenied
+	case failed > 0:
+		f.reason = reasonLDAPError
+		return nil, authFailed
+	}
+	return nil, authUnidentified
+}
+
+// checkGroups checks that the user is member of one of the required groups, if any.
+func (f *filter) checkGroups(conf *config, user *ldapUser) (*ldapUser, authResult) {
+	if len(conf.requiredGroups) == 0 {
+		return user, authSucceeded
+	}
+	for _, group := range user.groups(conf) {
+		for _, required := range conf.requiredGroups {
+			if equalDN(group, required) {
+				return user, authSucceeded
+			}
+		}
+	}
+	f.callbacks.Log(api.Debug, fmt.Sprintf("user %s is not member of the required groups", user.name()))
+	f.reason = reasonNotInGroup
+	return nil, authDenied
+}
+
+// checkRequiredGroups checks that the user authenticated by a backend is member of one of the
+// requiredGroups of the filter, if any. The groups are read with the group attribute of the backend.
+func (f *filter) checkRequiredGroups(user *ldapUser) (*ldapUser, authResult) {
+	if len(f.config.requiredGroups) == 0 || user.memberOf(f.config.requiredGroups) {
+		return user, authSucceeded
+	}
+	f.callbacks.Log(api.Debug, fmt.Sprintf("user %s is not member of the required groups", user.name()))
+	f.reason = reasonNotInGroup
+	return nil, authDenied
+}
+
+// equalDN returns true if both DNs are equal, ignoring the case.
+func equalDN(a, b string) bool {
+	dnA, errA := ldap.ParseDN(a)
+	dnB, errB := ldap.ParseDN(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return dnA.EqualFold(dnB)
+}
+
+// lookupEntry looks up the entry of the user by its DN.
+func (f *filter) lookupEntry(client *ldapConn, conf *config, userDN string) (*ldap.Entry, error) {
+	return searchEntry(client, conf, userDN, ldap.ScopeBaseObject, "(objectClass=*)", userDN)
+}
+
+// lookupBoundUser looks up the entry of the user bound in bind mode.
+func (f *filter) lookupBoundUser(client *ldapConn, conf *config, username, bindName string) (*ldap.Entry, error) {
+	switch conf.bindFormat {
+	case bindFormatUPN:
+		filter := fmt.Sprintf("(userPrincipalName=%s)", ldap.EscapeFilter(bindName))
+		return searchEntry(client, conf, conf.baseDN, ldap.ScopeWholeSubtree, filter, bindName)
+	case bindFormatNetBIOS:
+		local, _ := splitUsername(username)
+		filter := fmt.Sprintf("(sAMAccountName=%s)", ldap.EscapeFilter(local))
+		return searchEntry(client, conf, conf.baseDN, ldap.ScopeWholeSubtree, filter, bindName)
+	}
+	return f.lookupEntry(client, conf, bindName)
+}
+
+// searchEntry searches the unique entry of the user with the given name.
+func searchEntry(client *ldapConn, conf *config, baseDN string, scope int, filter, name string) (*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		baseDN,
+		scope,
+		ldap.NeverDerefAliases,
+		2,
+		conf.timeLimit,
+		false,
+		filter,
+		conf.searchAttributes(), nil)
+	sr, err := search(client, conf, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(sr.Entries) != 1 {
+		return nil, fmt.Errorf("found %d entries for %s", len(sr.Entries), name)
+	}
+	return sr.Entries[0], nil
+}
@@ -0,0 +1,97 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The formats of the name used to bind as the user in bind mode.
+const (
+	bindFormatDN      = "dn"
+	bindFormatUPN     = "upn"
+	bindFormatNetBIOS = "netbios"
+)
+
+// escapeDN escapes a value used in a distinguished name as described in RFC 4514.
+func escapeDN(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case r == 0:
+			b.WriteString(`\00`)
+			continue
+		case strings.ContainsRune(`"+,;<>\=`, r),
+			i == 0 && (r == ' ' || r == '#'),
+			i == len(value)-1 && r == ' ':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// splitUsername splits the username into its local and domain part,
+// e.g. "alice@corp.example.com" or "CORP\alice" gives ("alice", "corp.example.com") or ("alice", "CORP").
+// The domain part is empty if the username has none.
+func splitUsername(username string) (local, domain string) {
+	if domain, local, ok := strings.Cut(username, `\`); ok {
+		return local, domain
+	}
+	if i := strings.LastIndex(username, "@"); i >= 0 {
+		return username[:i], username[i+1:]
+	}
+	return username, ""
+}
+
+// bindName returns the name used to bind as the user in bind mode.
+func bindName(conf *config, username string) (string, error) {
+	local, domain := splitUsername(username)
+	if strings.ContainsAny(local, "\x00\r\n") || strings.ContainsAny(domain, "\x00\r\n") {
+		return "", fmt.Errorf("invalid username %q", username)
+	}
+
+	switch conf.bindFormat {
+	case bindFormatUPN:
+		if strings.Contains(username, "@") {
+			return local + "@" + domain, nil
+		}
+		if conf.upnDomain == "" {
+			return "", fmt.Errorf("no domain for username %q", username)
+		}
+		return local + "@" + conf.upnDomain, nil
+	case bindFormatNetBIOS:
+		if strings.Contains(username, `\`) {
+			return domain + `\` + local, nil
+		}
+		if conf.netbiosDomain == "" {
+			return "", fmt.Errorf("no domain for username %q", username)
+		}
+		return conf.netbiosDomain + `\` + local, nil
+	}
+
+	if conf.bindDNTemplate == "" {
+		return fmt.Sprintf("%s=%s,%s", conf.attribute, escapeDN(username), conf.baseDN), nil
+	}
+	return strings.NewReplacer(
+		"{username}", escapeDN(username),
+		"{local}", escapeDN(local),
+		"{domain}", escapeDN(domain),
+	).Replace(conf.bindDNTemplate), nil
+}
@@ -18,30 +18,301 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	xds "github.com/cncf/xds/go/xds/type/v3"
 	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
 	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/http"
+	"github.com/go-ldap/ldap/v3"
 	"google.golang.org/protobuf/types/known/anypb"
+	"math"
+	"net"
+	"strings"
+	"time"
 )
 
+// pluginName is the name of the filter, used as the namespace of its dynamic metadata.
+const pluginName = "envoy-go-ldap-auth"
+
 func init() {
-	http.RegisterHttpFilterConfigFactory("envoy-go-ldap-auth", configFactory)
+	http.RegisterHttpFilterConfigFactory(pluginName, configFactory)
 	http.RegisterHttpFilterConfigParser(&parser{})
 }
 
 type config struct {
-	host               string
-	port               uint64
-	baseDN             string
-	attribute          string
-	bindDN             string
-	password           string
-	filter             string
-	timeout            int32
-	tls                bool
-	startTLS           bool
-	insecureSkipVerify bool
-	rootCA             string
+	host                   string
+	port                   uint64
+	mode                   string
+	compareAttribute       string
+	socketPath             string
+	baseDN                 string
+	attribute              string
+	bindDNTemplate         string
+	bindFormat             string
+	upnDomain              string
+	netbiosDomain          string
+	bindDN                 string
+	password               string
+	filter                 string
+	searchBases            []searchBase
+	parallelSearch         bool
+	followReferrals        bool
+	referralHopLimit       int
+	globalCatalog          bool
+	scope                  string
+	attributes             []string
+	derefAliases           string
+	sizeLimit              int
+	timeLimit              int
+	pageSize               uint32
+	requiredGroups         []string
+	groupAttribute         string
+	accountChecks          []string
+	denyRules              []denyRule
+	timeout                int32
+	dialTimeout            time.Duration
+	operationTimeout       time.Duration
+	requestTimeout         time.Duration
+	requestTimeoutStatus   int
+	requestTimeoutMessage  string
+	tls                    bool
+	startTLS               bool
+	insecureSkipVerify     bool
+	rootCA                 string
+	rootCACerts            []*x509.Certificate
+	useSystemCA            bool
+	bindMethod             string
+	clientCert             string
+	clientKey              string
+	clientCertificate      *tls.Certificate
+	minTLSVersion          uint16
+	maxTLSVersion          uint16
+	cipherSuites           []uint16
+	serverName             string
+	pinnedPublicKeys       [][]byte
+	backends               []*config
+	backendPolicy          string
+	failureResponses       map[string]failureResponse
+	passwordExpiryHeader   string
+	passwordExpiryWarning  time.Duration
+	whoAmI                 bool
+	rejectIdentityMismatch bool
+	identityHeader         string
+	normalization          *usernameNormalization
+	credentialSources      []credentialSource
+	realm                  string
+	formLogin              *formLogin
+	revokePath             string
+	revokeAdmins           []string
+	accessRules            []*accessRule
+	accessControl          *accessControl
+	userAllowlist          *userList
+	userDenylist           *userList
+	sourceAllow            []*net.IPNet
+	sourceDeny             []*net.IPNet
+	groupSourceAllow       []groupSources
+	xffTrustedHops         int
+	timeWindows            []*timeWindow
+	timeWindowGroups       []string
+	logonHours             bool
+	localUsers             *reloadableFile
+	localUsersMode         string
+	authSourceHeader       string
+	// attributes of the user entry read by the authorization of the filter, and whether it reads the groups
+	authzAttributes []string
+	authzGroups     bool
+}
+
+// The modes to authenticate the user.
+const (
+	// bind as the user
+	modeBind = "bind"
+	// search the user with the service account, then bind as the user
+	modeSearch = "search"
+	// compare the password with the service account
+	modeCompare = "compare"
+)
+
+// hasSearchFilter returns true if the user can be searched.
+func (c *config) hasSearchFilter() bool {
+	return c.filter != "" || len(c.searchBases) > 0
+}
+
+// authMode returns the mode to authenticate the user.
+// It defaults to the search mode if a filter is given and to the bind mode otherwise.
+func (c *config) authMode() string {
+	if c.mode != "" {
+		return c.mode
+	}
+	if c.hasSearchFilter() {
+		return modeSearch
+	}
+	return modeBind
+}
+
+// compareAttr returns the attribute compared with the password in compare mode.
+func (c *config) compareAttr() string {
+	if c.compareAttribute != "" {
+		return c.compareAttribute
+	}
+	return "userPassword"
+}
+
+// userSearchBases returns the bases searched for the user in search mode,
+// the bases without filter use the filter option.
+func (c *config) userSearchBases() []searchBase {
+	if len(c.searchBases) == 0 {
+		return []searchBase{{baseDN: c.baseDN, filter: c.filter}}
+	}
+	bases := make([]searchBase, 0, len(c.searchBases))
+	for _, base := range c.searchBases {
+		if base.filter == "" {
+			base.filter = c.filter
+		}
+		bases = append(bases, base)
+	}
+	return bases
+}
+
+var searchScopes = map[string]int{
+	"base": ldap.ScopeBaseObject,
+	"one":  ldap.ScopeSingleLevel,
+	"sub":  ldap.ScopeWholeSubtree,
+}
+
+// searchScope returns the scope of the search in search mode, the whole subtree by default.
+func (c *config) searchScope() int {
+	if scope, ok := searchScopes[c.scope]; ok {
+		return scope
+	}
+	return ldap.ScopeWholeSubtree
+}
+
+var derefAliases = map[string]int{
+	"never":     ldap.NeverDerefAliases,
+	"searching": ldap.DerefInSearching,
+	"finding":   ldap.DerefFindingBaseObj,
+	"always":    ldap.DerefAlways,
+}
+
+// searchDerefAliases returns how the aliases are dereferenced in search mode, never by default.
+func (c *config) searchDerefAliases() int {
+	if deref, ok := derefAliases[c.derefAliases]; ok {
+		return deref
+	}
+	return ldap.NeverDerefAliases
+}
+
+// searchAttributes returns the attributes fetched in search mode.
+func (c *config) searchAttributes() []string {
+	attributes := c.attributes
+	if len(attributes) == 0 {
+		attributes = []string{"dn", "cn"}
+	}
+	for _, attr := range c.requiredAttributes() {
+		if !containsFold(attributes, attr) {
+			attributes = append(attributes[:len(attributes):len(attributes)], attr)
+		}
+	}
+	return attributes
+}
+
+// requiredAttributes returns the attributes of the user entry required to authorize the user.
+func (c *config) requiredAttributes() []string {
+	var attributes []string
+	if len(c.requiredGroups) > 0 || c.authzGroups {
+		attributes = append(attributes, c.groupAttr())
+	}
+	attributes = append(attributes, c.accountChecks...)
+	attributes = append(attributes, c.authzAttributes...)
+	for _, rule := range c.denyRules {
+		attributes = append(attributes, rule.attribute)
+	}
+	return attributes
+}
+
+// updateAuthzAttributes records the attributes of the user entry read by the authorization,
+// so that they are fetched by the config and its backends.
+func (c *config) updateAuthzAttributes() {
+	c.authzAttributes, c.authzGroups = ruleAttributes(c.accessRules)
+	// the requiredGroups of the top level are checked after the backends
+	c.authzGroups = c.authzGroups || c.accessControl.usesGroups() || len(c.groupSourceAllow) > 0 ||
+		len(c.timeWindowGroups) > 0 || (len(c.backends) > 0 && len(c.requiredGroups) > 0)
+	if c.logonHours {
+		c.authzAttributes = append(c.authzAttributes, "logonHours")
+	}
+	if len(c.backends) == 0 {
+		return
+	}
+	// the backends may be shared with other routes
+	backends := make([]*config, 0, len(c.backends))
+	for _, backend := range c.backends {
+		b := *backend
+		b.authzAttributes, b.authzGroups = c.authzAttributes, c.authzGroups
+		backends = append(backends, &b)
+	}
+	c.backends = backends
+}
+
+// groupAttr returns the attribute of the user entry listing the groups of the user.
+func (c *config) groupAttr() string {
+	if c.groupAttribute != "" {
+		return c.groupAttribute
+	}
+	return "memberOf"
+}
+
+// referralHops returns the number of referrals followed in a row.
+func (c *config) referralHops() int {
+	if c.referralHopLimit > 0 {
+		return c.referralHopLimit
+	}
+	return defaultReferralHopLimit
+}
+
+// connectTimeout returns the timeout to establish the connection with the LDAP server.
+func (c *config) connectTimeout() time.Duration {
+	if c.dialTimeout > 0 {
+		return c.dialTimeout
+	}
+	return time.Duration(c.timeout) * time.Second
+}
+
+// timeoutStatus returns the status code of the response sent when the requestTimeout is exceeded.
+func (c *config) timeoutStatus() int {
+	if c.requestTimeoutStatus != 0 {
+		return c.requestTimeoutStatus
+	}
+	return 503
+}
+
+// timeoutMessage returns the body of the response sent when the requestTimeout is exceeded.
+func (c *config) timeoutMessage() string {
+	if c.requestTimeoutMessage != "" {
+		return c.requestTimeoutMessage
+	}
+	return "authentication timeout"
+}
+
+// The bind methods which can be used by the service account in search mode.
+const (
+	bindMethodSimple    = "simple"
+	bindMethodAnonymous = "anonymous"
+	bindMethodExternal  = "external"
+)
+
+// serviceBindMethod returns the bind method used by the service account.
+// It defaults to a simple bind if a bindDn is given and to an anonymous bind otherwise.
+func (c *config) serviceBindMethod() string {
+	if c.bindMethod != "" {
+		return c.bindMethod
+	}
+	if c.bindDN != "" {
+		return bindMethodSimple
+	}
+	return bindMethodAnonymous
 }
 
 type parser struct {
@@ -54,8 +325,44 @@ func (p *parser) Parse(any *anypb.Any) (interface{}, error) {
 	}
 
 	v := configStruct.Value
+	return parseConfig(v.AsMap(), true)
+}
+
+// topLevelOptions are the options of the filter which don't apply to a backend.
+var topLevelOptions = []string{
+	"accessControl", "accessRules", "authSourceHeader", "backendPolicy", "credentialSources",
+	"failureResponses", "formLogin", "groupSourceAllow", "identityHeader", "localUsersFile",
+	"localUsersMode", "logonHours", "passwordExpiryHeader", "passwordExpiryWarning", "realm",
+	"requestTimeout", "requestTimeoutMessage", "requestTimeoutStatus", "revokeAdmins", "revokePath",
+	"sourceAllow", "sourceDeny", "timeWindowGroups", "timeWindows", "userAllowlist",
+	"userAllowlistFile", "userDenylist", "userDenylistFile", "usernameNormalization", "xffTrustedHops",
+}
+
+// parseConfig parses the config of the filter, or the config of a backend if allowBackends is false.
+func parseConfig(m map[string]interface{}, allowBackends bool) (*config, error) {
+	if !allowBackends {
+		for _, key := range topLevelOptions {
+			if _, ok := m[key]; ok {
+				return nil, fmt.Errorf("%s can only be set at the top level, not in a backend", key)
+			}
+		}
+	}
 	conf := &config{}
-	m := v.AsMap()
+	// the components of the URL can be overridden by the other options
+	if rawURL, ok := m["url"].(string); ok {
+		u, err := parseLDAPURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		conf.host = u.host
+		conf.port = u.port
+		conf.socketPath = u.socketPath
+		conf.tls = u.scheme == "ldaps"
+		conf.baseDN = u.baseDN
+		conf.attributes = u.attributes
+		conf.scope = u.scope
+		conf.filter = u.filter
+	}
 	if host, ok := m["host"].(string); ok {
 		conf.host = host
 	}
@@ -68,6 +375,26 @@ func (p *parser) Parse(any *anypb.Any) (interface{}, error) {
 	if attribute, ok := m["attribute"].(string); ok {
 		conf.attribute = attribute
 	}
+	if bindDNTemplate, ok := m["bindDnTemplate"].(string); ok && bindDNTemplate != "" {
+		if !strings.Contains(bindDNTemplate, "{username}") && !strings.Contains(bindDNTemplate, "{local}") {
+			return nil, errors.New("bindDnTemplate must contain the {username} or {local} placeholder")
+		}
+		conf.bindDNTemplate = bindDNTemplate
+	}
+	if bindFormat, ok := m["bindFormat"].(string); ok {
+		switch bindFormat {
+		case bindFormatDN, bindFormatUPN, bindFormatNetBIOS:
+			conf.bindFormat = bindFormat
+		default:
+			return nil, fmt.Errorf("unknown bindFormat: %s", bindFormat)
+		}
+	}
+	if upnDomain, ok := m["upnDomain"].(string); ok {
+		conf.upnDomain = upnDomain
+	}
+	if netbiosDomain, ok := m["netbiosDomain"].(string); ok {
+		conf.netbiosDomain = netbiosDomain
+	}
 	if bindDN, ok := m["bindDn"].(string); ok {
 		conf.bindDN = bindDN
 	}
@@ -77,27 +404,502 @@ func (p *parser) Parse(any *anypb.Any) (interface{}, error) {
 	if cFilter, ok := m["filter"].(string); ok {
 		conf.filter = cFilter
 	}
+	if searchBases, ok := m["searchBases"]; ok {
+		list, ok := searchBases.([]interface{})
+		if !ok {
+			return nil, errors.New("invalid searchBases: should be a list")
+		}
+		for _, item := range list {
+			base, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("invalid searchBases: unexpected item, should be an object")
+			}
+			baseDN, _ := base["baseDn"].(string)
+			filter, _ := base["filter"].(string)
+			if baseDN == "" {
+				return nil, errors.New("invalid searchBases: missing baseDn")
+			}
+			if filter == "" && conf.filter == "" {
+				return nil, fmt.Errorf("invalid searchBases: missing filter for %s", baseDN)
+			}
+			conf.searchBases = append(conf.searchBases, searchBase{baseDN: baseDN, filter: filter})
+		}
+	}
+	if parallelSearch, ok := m["parallelSearch"].(bool); ok {
+		conf.parallelSearch = parallelSearch
+	}
+	if followReferrals, ok := m["followReferrals"].(bool); ok {
+		conf.followReferrals = followReferrals
+	}
+	if referralHopLimit, ok := m["referralHopLimit"].(float64); ok {
+		if referralHopLimit < 1 {
+			return nil, errors.New("referralHopLimit must be positive")
+		}
+		conf.referralHopLimit = int(referralHopLimit)
+	}
+	if scope, ok := m["scope"].(string); ok {
+		if _, ok := searchScopes[scope]; !ok {
+			return nil, fmt.Errorf("unknown scope: %s", scope)
+		}
+		conf.scope = scope
+	}
+	if deref, ok := m["derefAliases"].(string); ok {
+		if _, ok := derefAliases[deref]; !ok {
+			return nil, fmt.Errorf("unknown derefAliases: %s", deref)
+		}
+		conf.derefAliases = deref
+	}
+	if sizeLimit, ok := m["sizeLimit"].(float64); ok {
+		if sizeLimit < 0 {
+			return nil, errors.New("sizeLimit must not be negative")
+		}
+		conf.sizeLimit = int(sizeLimit)
+	}
+	if timeLimit, ok := m["timeLimit"].(float64); ok {
+		if timeLimit < 0 {
+			return nil, errors.New("timeLimit must not be negative")
+		}
+		conf.timeLimit = int(timeLimit)
+	}
+	if pageSize, ok := m["pageSize"].(float64); ok {
+		if pageSize < 0 || pageSize > math.MaxUint32 {
+			return nil, fmt.Errorf("invalid pageSize: %v", pageSize)
+		}
+		conf.pageSize = uint32(pageSize)
+	}
+	if attributes, ok := m["attributes"]; ok {
+		attrs, err := toStringSlice(attributes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attributes: %v", err)
+		}
+		for _, attr := range attrs {
+			if attr == "" {
+				return nil, errors.New("invalid attributes: empty attribute name")
+			}
+		}
+		conf.attributes = attrs
+	}
+	if requiredGroups, ok := m["requiredGroups"]; ok {
+		groups, err := toStringSlice(requiredGroups)
+		if err != nil {
+			return nil, fmt.Errorf("invalid requiredGroups: %v", err)
+		}
+		conf.requiredGroups = groups
+	}
+	if groupAttribute, ok := m["groupAttribute"].(string); ok {
+		conf.groupAttribute = groupAttribute
+	}
+	if checks, ok := m["accountChecks"]; ok {
+		names, err := toStringSlice(checks)
+		if err != nil {
+			return nil, fmt.Errorf("invalid accountChecks: %v", err)
+		}
+		for _, name := range names {
+			if !accountChecks[name] {
+				return nil, fmt.Errorf("invalid accountChecks: unknown check %s", name)
+			}
+		}
+		conf.accountChecks = names
+	}
+	if denyAttributes, ok := m["denyAttributes"]; ok {
+		list, ok := denyAttributes.([]interface{})
+		if !ok {
+			return nil, errors.New("invalid denyAttributes: should be a list")
+		}
+		for _, item := range list {
+			rm, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("invalid denyAttributes: unexpected item, should be an object")
+			}
+			rule := denyRule{}
+			rule.attribute, _ = rm["attribute"].(string)
+			if rule.attribute == "" {
+				return nil, errors.New("invalid denyAttributes: missing attribute")
+			}
+			if values, ok := rm["values"]; ok {
+				var err error
+				if rule.values, err = toStringSlice(values); err != nil {
+					return nil, fmt.Errorf("invalid denyAttributes: invalid values of %s: %v", rule.attribute, err)
+				}
+			}
+			conf.denyRules = append(conf.denyRules, rule)
+		}
+	}
 	if timeout, ok := m["timeout"].(float64); ok {
 		conf.timeout = int32(timeout)
 	}
 	if conf.timeout == 0 {
 		conf.timeout = 60
 	}
+	for key, d := range map[string]*time.Duration{
+		"dialTimeout":      &conf.dialTimeout,
+		"operationTimeout": &conf.operationTimeout,
+		"requestTimeout":   &conf.requestTimeout,
+	} {
+		if seconds, ok := m[key].(float64); ok {
+			if seconds < 0 {
+				return nil, fmt.Errorf("%s must not be negative", key)
+			}
+			*d = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	if code, ok := m["requestTimeoutStatus"].(float64); ok {
+		if code < 200 || code > 599 {
+			return nil, fmt.Errorf("invalid requestTimeoutStatus: %v", code)
+		}
+		conf.requestTimeoutStatus = int(code)
+	}
+	if body, ok := m["requestTimeoutMessage"].(string); ok {
+		conf.requestTimeoutMessage = body
+	}
 	if tls, ok := m["tls"].(bool); ok {
 		conf.tls = tls
 	}
 	if startTLS, ok := m["startTls"].(bool); ok {
 		conf.startTLS = startTLS
 	}
+	if globalCatalog, ok := m["globalCatalog"].(bool); ok {
+		conf.globalCatalog = globalCatalog
+	}
+	// the Global Catalog listens on its own ports, unless the port is set explicitly
+	if _, ok := m["port"]; conf.globalCatalog && !ok {
+		conf.port = 3268
+		if conf.tls && !conf.startTLS {
+			conf.port = 3269
+		}
+	}
 	if insecureSkipVerify, ok := m["insecureSkipVerify"].(bool); ok {
 		conf.insecureSkipVerify = insecureSkipVerify
 	}
-	if rootCA, ok := m["rootCA"].(string); ok {
+	if rootCA, ok := m["rootCA"].(string); ok && rootCA != "" {
+		certs, err := parseCertificates(rootCA)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rootCA: %v", err)
+		}
+		reportCertificateExpiry("rootCA", certs)
 		conf.rootCA = rootCA
+		conf.rootCACerts = certs
+	}
+	if useSystemCA, ok := m["useSystemCA"].(bool); ok {
+		if useSystemCA {
+			if _, err := x509.SystemCertPool(); err != nil {
+				return nil, fmt.Errorf("failed to load the system trust store: %v", err)
+			}
+		}
+		conf.useSystemCA = useSystemCA
+	}
+	if bindMethod, ok := m["bindMethod"].(string); ok {
+		switch bindMethod {
+		case bindMethodSimple, bindMethodAnonymous, bindMethodExternal:
+			conf.bindMethod = bindMethod
+		default:
+			return nil, fmt.Errorf("unknown bindMethod: %s", bindMethod)
+		}
+	}
+	if clientCert, ok := m["clientCert"].(string); ok {
+		conf.clientCert = clientCert
+	}
+	if clientKey, ok := m["clientKey"].(string); ok {
+		conf.clientKey = clientKey
+	}
+	if conf.clientCert != "" || conf.clientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(conf.clientCert), []byte(conf.clientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid clientCert or clientKey: %v", err)
+		}
+		if cert.Leaf == nil {
+			cert.Leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+		}
+		if cert.Leaf != nil {
+			reportCertificateExpiry("clientCert", []*x509.Certificate{cert.Leaf})
+		}
+		conf.clientCertificate = &cert
+	}
+	if minTLSVersion, ok := m["minTlsVersion"].(string); ok {
+		v, err := parseTLSVersion(minTLSVersion)
+		if err != nil {
+			return nil, err
+		}
+		conf.minTLSVersion = v
+	}
+	if maxTLSVersion, ok := m["maxTlsVersion"].(string); ok {
+		v, err := parseTLSVersion(maxTLSVersion)
+		if err != nil {
+			return nil, err
+		}
+		conf.maxTLSVersion = v
+	}
+	if conf.minTLSVersion != 0 && conf.maxTLSVersion != 0 && conf.minTLSVersion > conf.maxTLSVersion {
+		return nil, errors.New("minTlsVersion must not be greater than maxTlsVersion")
+	}
+	if cipherSuites, ok := m["cipherSuites"]; ok {
+		names, err := toStringSlice(cipherSuites)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cipherSuites: %v", err)
+		}
+		conf.cipherSuites, err = parseCipherSuites(names)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if serverName, ok := m["serverName"].(string); ok {
+		conf.serverName = serverName
+	}
+	if pinnedPublicKeys, ok := m["pinnedPublicKeys"]; ok {
+		pins, err := toStringSlice(pinnedPublicKeys)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pinnedPublicKeys: %v", err)
+		}
+		for _, pin := range pins {
+			hash, err := parsePinnedPublicKey(pin)
+			if err != nil {
+				return nil, err
+			}
+			conf.pinnedPublicKeys = append(conf.pinnedPublicKeys, hash)
+		}
+	}
+	if mode, ok := m["mode"].(string); ok {
+		switch mode {
+		case modeBind, modeSearch, modeCompare:
+			conf.mode = mode
+		default:
+			return nil, fmt.Errorf("unknown mode: %s", mode)
+		}
+	}
+	if conf.mode == modeSearch && !conf.hasSearchFilter() {
+		return nil, errors.New("search mode requires a filter")
+	}
+	if conf.mode == modeCompare && !conf.hasSearchFilter() && (conf.bindFormat == bindFormatUPN || conf.bindFormat == bindFormatNetBIOS) {
+		return nil, errors.New("compare mode requires a filter or a DN bindFormat to resolve the DN of the user")
+	}
+	if compareAttribute, ok := m["compareAttribute"].(string); ok {
+		conf.compareAttribute = compareAttribute
+	}
+	// over ldapi the identity is derived from the credentials of the process
+	if conf.bindMethod == bindMethodExternal && conf.socketPath == "" {
+		if !conf.tls {
+			return nil, errors.New("bindMethod external requires tls to be enabled")
+		}
+		if conf.clientCertificate == nil {
+			return nil, errors.New("bindMethod external requires clientCert and clientKey")
+		}
+	}
+	if failureResponses, ok := m["failureResponses"]; ok {
+		responses, ok := failureResponses.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid failureResponses: should be an object")
+		}
+		conf.failureResponses = make(map[string]failureResponse, len(responses))
+		for reason, v := range responses {
+			if !failureReasons[reason] {
+				return nil, fmt.Errorf("invalid failureResponses: unknown reason %s", reason)
+			}
+			rm, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid failureResponses: %s should be an object", reason)
+			}
+			var resp failureResponse
+			if status, ok := rm["status"].(float64); ok {
+				if status < 200 || status > 599 {
+					return nil, fmt.Errorf("invalid failureResponses: invalid status %v for %s", status, reason)
+				}
+				resp.status = int(status)
+			}
+			resp.message, _ = rm["message"].(string)
+			conf.failureResponses[reason] = resp
+		}
+	}
+	if passwordExpiryHeader, ok := m["passwordExpiryHeader"].(string); ok {
+		conf.passwordExpiryHeader = passwordExpiryHeader
+	}
+	if passwordExpiryWarning, ok := m["passwordExpiryWarning"].(float64); ok {
+		if passwordExpiryWarning < 0 {
+			return nil, errors.New("passwordExpiryWarning must not be negative")
+		}
+		conf.passwordExpiryWarning = time.Duration(passwordExpiryWarning * float64(time.Second))
+	}
+	if whoAmI, ok := m["whoAmI"].(bool); ok {
+		conf.whoAmI = whoAmI
+	}
+	if rejectIdentityMismatch, ok := m["rejectIdentityMismatch"].(bool); ok {
+		conf.rejectIdentityMismatch = rejectIdentityMismatch
+	}
+	if identityHeader, ok := m["identityHeader"].(string); ok {
+		conf.identityHeader = identityHeader
+	}
+	if normalization, ok := m["usernameNormalization"]; ok {
+		n, err := parseUsernameNormalization(normalization)
+		if err != nil {
+			return nil, err
+		}
+		conf.normalization = n
+	}
+	if credentialSources, ok := m["credentialSources"]; ok {
+		sources, err := parseCredentialSources(credentialSources)
+		if err != nil {
+			return nil, err
+		}
+		conf.credentialSources = sources
+	}
+	if realm, ok := m["realm"].(string); ok {
+		conf.realm = realm
+	}
+	if fl, ok := m["formLogin"]; ok {
+		formLogin, err := parseFormLogin(fl)
+		if err != nil {
+			return nil, err
+		}
+		conf.formLogin = formLogin
+	}
+	if revokePath, ok := m["revokePath"].(string); ok {
+		conf.revokePath = revokePath
+	}
+	if revokeAdmins, ok := m["revokeAdmins"]; ok {
+		admins, err := toStringSlice(revokeAdmins)
+		if err != nil {
+			return nil, fmt.Errorf("invalid revokeAdmins: %v", err)
+		}
+		conf.revokeAdmins = admins
+	}
+	if conf.revokePath != "" && len(conf.revokeAdmins) == 0 {
+		return nil, errors.New("revokePath requires revokeAdmins")
+	}
+	if accessRules, ok := m["accessRules"]; ok {
+		sources, err := toStringSlice(accessRules)
+		if err != nil {
+			return nil, fmt.Errorf("invalid accessRules: %v", err)
+		}
+		for _, source := range sources {
+			rule, err := parseAccessRule(source)
+			if err != nil {
+				return nil, fmt.Errorf("invalid access rule %q: %v", source, err)
+			}
+			conf.accessRules = append(conf.accessRules, rule)
+		}
+	}
+	if ac, ok := m["accessControl"]; ok {
+		acl, err := parseAccessControl(ac)
+		if err != nil {
+			return nil, err
+		}
+		conf.accessControl = acl
+	}
+	allowlist, err := parseUserList(m, "userAllowlist", "userAllowlistFile")
+	if err != nil {
+		return nil, err
+	}
+	conf.userAllowlist = allowlist
+	denylist, err := parseUserList(m, "userDenylist", "userDenylistFile")
+	if err != nil {
+		return nil, err
+	}
+	conf.userDenylist = denylist
+	for key, nets := range map[string]*[]*net.IPNet{"sourceAllow": &conf.sourceAllow, "sourceDeny": &conf.sourceDeny} {
+		if v, ok := m[key]; ok {
+			if *nets, err = parseCIDRs(v); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", key, err)
+			}
+		}
+	}
+	if v, ok := m["groupSourceAllow"]; ok {
+		if conf.groupSourceAllow, err = parseGroupSources(v); err != nil {
+			return nil, err
+		}
+	}
+	if hops, ok := m["xffTrustedHops"].(float64); ok {
+		if hops < 0 {
+			return nil, errors.New("xffTrustedHops must not be negative")
+		}
+		conf.xffTrustedHops = int(hops)
+	}
+	if v, ok := m["timeWindows"]; ok {
+		if conf.timeWindows, err = parseTimeWindows(v); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := m["timeWindowGroups"]; ok {
+		if conf.timeWindowGroups, err = toStringSlice(v); err != nil {
+			return nil, fmt.Errorf("invalid timeWindowGroups: %v", err)
+		}
+	}
+	if logonHours, ok := m["logonHours"].(bool); ok {
+		conf.logonHours = logonHours
+	}
+	if localUsersFile, ok := m["localUsersFile"].(string); ok && localUsersFile != "" {
+		if conf.localUsers, err = newReloadableFile(localUsersFile, parseHtpasswd); err != nil {
+			return nil, fmt.Errorf("invalid localUsersFile: %v", err)
+		}
+	}
+	if mode, ok := m["localUsersMode"].(string); ok && mode != "" {
+		if mode != localUsersFirst && mode != localUsersFallback {
+			return nil, fmt.Errorf("unknown localUsersMode: %s", mode)
+		}
+		conf.localUsersMode = mode
+	}
+	if authSourceHeader, ok := m["authSourceHeader"].(string); ok {
+		conf.authSourceHeader = authSourceHeader
+	}
+	if backends, ok := m["backends"]; ok {
+		if !allowBackends {
+			return nil, errors.New("backends can not be nested")
+		}
+		list, ok := backends.([]interface{})
+		if !ok {
+			return nil, errors.New("invalid backends: should be a list")
+		}
+		for i, item := range list {
+			bm, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("invalid backends: unexpected item, should be an object")
+			}
+			backend, err := parseConfig(bm, false)
+			if err != nil {
+				return nil, fmt.Errorf("invalid backend %d: %v", i, err)
+			}
+			conf.backends = append(conf.backends, backend)
+		}
+	}
+	if backendPolicy, ok := m["backendPolicy"].(string); ok {
+		switch backendPolicy {
+		case backendPolicyFirstMatch, backendPolicyExactlyOne:
+			conf.backendPolicy = backendPolicy
+		default:
+			return nil, fmt.Errorf("unknown backendPolicy: %s", backendPolicy)
+		}
+	}
+	if allowBackends {
+		conf.updateAuthzAttributes()
 	}
 	return conf, nil
 }
 
+// containsFold returns true if the list contains the string, ignoring the case.
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// toStringSlice converts a list value of the config to a slice of strings.
+func toStringSlice(v interface{}) ([]string, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("should be a list")
+	}
+	ss := make([]string, 0, len(list))
+	for _, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected item %v, should be a string", item)
+		}
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
 func (p *parser) Merge(parent interface{}, child interface{}) interface{} {
 	parentConfig := parent.(*config)
 	childConfig := child.(*config)
@@ -109,12 +911,33 @@ func (p *parser) Merge(parent interface{}, child interface{}) interface{} {
 	if childConfig.port != 0 {
 		newConfig.port = childConfig.port
 	}
+	if childConfig.mode != "" {
+		newConfig.mode = childConfig.mode
+	}
+	if childConfig.compareAttribute != "" {
+		newConfig.compareAttribute = childConfig.compareAttribute
+	}
+	if childConfig.socketPath != "" {
+		newConfig.socketPath = childConfig.socketPath
+	}
 	if childConfig.baseDN != "" {
 		newConfig.baseDN = childConfig.baseDN
 	}
 	if childConfig.attribute != "" {
 		newConfig.attribute = childConfig.attribute
 	}
+	if childConfig.bindDNTemplate != "" {
+		newConfig.bindDNTemplate = childConfig.bindDNTemplate
+	}
+	if childConfig.bindFormat != "" {
+		newConfig.bindFormat = childConfig.bindFormat
+	}
+	if childConfig.upnDomain != "" {
+		newConfig.upnDomain = childConfig.upnDomain
+	}
+	if childConfig.netbiosDomain != "" {
+		newConfig.netbiosDomain = childConfig.netbiosDomain
+	}
 	if childConfig.bindDN != "" {
 		newConfig.bindDN = childConfig.bindDN
 	}
@@ -124,9 +947,69 @@ func (p *parser) Merge(parent interface{}, child interface{}) interface{} {
 	if childConfig.filter != "" {
 		newConfig.filter = childConfig.filter
 	}
+	if len(childConfig.searchBases) > 0 {
+		newConfig.searchBases = childConfig.searchBases
+	}
+	if childConfig.parallelSearch {
+		newConfig.parallelSearch = childConfig.parallelSearch
+	}
+	if childConfig.followReferrals {
+		newConfig.followReferrals = childConfig.followReferrals
+	}
+	if childConfig.referralHopLimit != 0 {
+		newConfig.referralHopLimit = childConfig.referralHopLimit
+	}
+	if childConfig.globalCatalog {
+		newConfig.globalCatalog = childConfig.globalCatalog
+	}
+	if childConfig.scope != "" {
+		newConfig.scope = childConfig.scope
+	}
+	if len(childConfig.attributes) > 0 {
+		newConfig.attributes = childConfig.attributes
+	}
+	if len(childConfig.requiredGroups) > 0 {
+		newConfig.requiredGroups = childConfig.requiredGroups
+	}
+	if childConfig.groupAttribute != "" {
+		newConfig.groupAttribute = childConfig.groupAttribute
+	}
+	if len(childConfig.accountChecks) > 0 {
+		newConfig.accountChecks = childConfig.accountChecks
+	}
+	if len(childConfig.denyRules) > 0 {
+		newConfig.denyRules = childConfig.denyRules
+	}
+	if childConfig.derefAliases != "" {
+		newConfig.derefAliases = childConfig.derefAliases
+	}
+	if childConfig.sizeLimit != 0 {
+		newConfig.sizeLimit = childConfig.sizeLimit
+	}
+	if childConfig.timeLimit != 0 {
+		newConfig.timeLimit = childConfig.timeLimit
+	}
+	if childConfig.pageSize != 0 {
+		newConfig.pageSize = childConfig.pageSize
+	}
 	if childConfig.timeout != 0 {
 		newConfig.timeout = childConfig.timeout
 	}
+	if childConfig.dialTimeout != 0 {
+		newConfig.dialTimeout = childConfig.dialTimeout
+	}
+	if childConfig.operationTimeout != 0 {
+		newConfig.operationTimeout = childConfig.operationTimeout
+	}
+	if childConfig.requestTimeout != 0 {
+		newConfig.requestTimeout = childConfig.requestTimeout
+	}
+	if childConfig.requestTimeoutStatus != 0 {
+		newConfig.requestTimeoutStatus = childConfig.requestTimeoutStatus
+	}
+	if childConfig.requestTimeoutMessage != "" {
+		newConfig.requestTimeoutMessage = childConfig.requestTimeoutMessage
+	}
 	if childConfig.tls {
 		newConfig.tls = childConfig.tls
 	}
@@ -138,7 +1021,119 @@ func (p *parser) Merge(parent interface{}, child interface{}) interface{} {
 	}
 	if childConfig.rootCA != "" {
 		newConfig.rootCA = childConfig.rootCA
+		newConfig.rootCACerts = childConfig.rootCACerts
+	}
+	if childConfig.useSystemCA {
+		newConfig.useSystemCA = childConfig.useSystemCA
+	}
+	if childConfig.bindMethod != "" {
+		newConfig.bindMethod = childConfig.bindMethod
+	}
+	if childConfig.clientCertificate != nil {
+		newConfig.clientCert = childConfig.clientCert
+		newConfig.clientKey = childConfig.clientKey
+		newConfig.clientCertificate = childConfig.clientCertificate
+	}
+	if childConfig.minTLSVersion != 0 {
+		newConfig.minTLSVersion = childConfig.minTLSVersion
+	}
+	if childConfig.maxTLSVersion != 0 {
+		newConfig.maxTLSVersion = childConfig.maxTLSVersion
+	}
+	if len(childConfig.cipherSuites) > 0 {
+		newConfig.cipherSuites = childConfig.cipherSuites
+	}
+	if childConfig.serverName != "" {
+		newConfig.serverName = childConfig.serverName
+	}
+	if len(childConfig.pinnedPublicKeys) > 0 {
+		newConfig.pinnedPublicKeys = childConfig.pinnedPublicKeys
+	}
+	if len(childConfig.failureResponses) > 0 {
+		newConfig.failureResponses = childConfig.failureResponses
+	}
+	if childConfig.passwordExpiryHeader != "" {
+		newConfig.passwordExpiryHeader = childConfig.passwordExpiryHeader
+	}
+	if childConfig.passwordExpiryWarning != 0 {
+		newConfig.passwordExpiryWarning = childConfig.passwordExpiryWarning
+	}
+	if childConfig.whoAmI {
+		newConfig.whoAmI = childConfig.whoAmI
+	}
+	if childConfig.rejectIdentityMismatch {
+		newConfig.rejectIdentityMismatch = childConfig.rejectIdentityMismatch
+	}
+	if childConfig.identityHeader != "" {
+		newConfig.identityHeader = childConfig.identityHeader
+	}
+	if childConfig.normalization != nil {
+		newConfig.normalization = childConfig.normalization
+	}
+	if len(childConfig.credentialSources) > 0 {
+		newConfig.credentialSources = childConfig.credentialSources
+	}
+	if childConfig.realm != "" {
+		newConfig.realm = childConfig.realm
+	}
+	if childConfig.formLogin != nil {
+		newConfig.formLogin = childConfig.formLogin
+	}
+	if childConfig.revokePath != "" {
+		newConfig.revokePath = childConfig.revokePath
+	}
+	if len(childConfig.revokeAdmins) > 0 {
+		newConfig.revokeAdmins = childConfig.revokeAdmins
+	}
+	if len(childConfig.accessRules) > 0 {
+		newConfig.accessRules = childConfig.accessRules
+	}
+	if childConfig.accessControl != nil {
+		newConfig.accessControl = childConfig.accessControl
+	}
+	if childConfig.userAllowlist != nil {
+		newConfig.userAllowlist = childConfig.userAllowlist
+	}
+	if childConfig.userDenylist != nil {
+		newConfig.userDenylist = childConfig.userDenylist
+	}
+	if len(childConfig.sourceAllow) > 0 {
+		newConfig.sourceAllow = childConfig.sourceAllow
+	}
+	if len(childConfig.sourceDeny) > 0 {
+		newConfig.sourceDeny = childConfig.sourceDeny
+	}
+	if len(childConfig.groupSourceAllow) > 0 {
+		newConfig.groupSourceAllow = childConfig.groupSourceAllow
+	}
+	if childConfig.xffTrustedHops != 0 {
+		newConfig.xffTrustedHops = childConfig.xffTrustedHops
+	}
+	if len(childConfig.timeWindows) > 0 {
+		newConfig.timeWindows = childConfig.timeWindows
+	}
+	if len(childConfig.timeWindowGroups) > 0 {
+		newConfig.timeWindowGroups = childConfig.timeWindowGroups
+	}
+	if childConfig.logonHours {
+		newConfig.logonHours = childConfig.logonHours
+	}
+	if childConfig.localUsers != nil {
+		newConfig.localUsers = childConfig.localUsers
+	}
+	if childConfig.localUsersMode != "" {
+		newConfig.localUsersMode = childConfig.localUsersMode
+	}
+	if childConfig.authSourceHeader != "" {
+		newConfig.authSourceHeader = childConfig.authSourceHeader
+	}
+	if len(childConfig.backends) > 0 {
+		newConfig.backends = childConfig.backends
+	}
+	if childConfig.backendPolicy != "" {
+		newConfig.backendPolicy = childConfig.backendPolicy
 	}
+	newConfig.updateAuthzAttributes()
 	return &newConfig
 }
 
@@ -0,0 +1,161 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"strings"
+)
+
+// The kinds of sources the credentials of a request are read from.
+const (
+	credentialAuthorization      = "authorization"
+	credentialProxyAuthorization = "proxyAuthorization"
+	credentialHeaders            = "headers"
+	credentialBasicHeader        = "basicHeader"
+)
+
+// credentialSource is a place of the request the credentials are read from.
+type credentialSource struct {
+	kind string
+	// the headers of the username and the password for the headers source
+	usernameHeader string
+	passwordHeader string
+	// the header holding the Basic credentials for the basicHeader source
+	header string
+}
+
+var defaultCredentialSources = []credentialSource{{kind: credentialAuthorization}}
+
+// parseCredentialSources parses the credentialSources option.
+func parseCredentialSources(v interface{}) ([]credentialSource, error) {
+	list, ok := v.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, errors.New("invalid credentialSources: should be a non-empty list")
+	}
+	sources := make([]credentialSource, 0, len(list))
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid credentialSources: source should be an object")
+		}
+		var s credentialSource
+		s.kind, _ = m["type"].(string)
+		switch s.kind {
+		case credentialAuthorization, credentialProxyAuthorization:
+		case credentialHeaders:
+			s.usernameHeader, _ = m["usernameHeader"].(string)
+			s.passwordHeader, _ = m["passwordHeader"].(string)
+			if s.usernameHeader == "" || s.passwordHeader == "" {
+				return nil, errors.New("invalid credentialSources: headers requires usernameHeader and passwordHeader")
+			}
+		case credentialBasicHeader:
+			s.header, _ = m["header"].(string)
+			if s.header == "" {
+				return nil, errors.New("invalid credentialSources: basicHeader requires header")
+			}
+		default:
+			return nil, fmt.Errorf("invalid credentialSources: unknown type %q", s.kind)
+		}
+		sources = append(sources, s)
+	}
+	return sources, nil
+}
+
+// credentials reads the credentials from the source, found is false if the request doesn't carry them,
+// ok is false if they are malformed.
+func (s *credentialSource) credentials(header api.RequestHeaderMap) (username, password string, found, ok bool) {
+	switch s.kind {
+	case credentialHeaders:
+		username, found = header.Get(s.usernameHeader)
+		if !found {
+			return "", "", false, false
+		}
+		password, ok = header.Get(s.passwordHeader)
+		return username, password, true, ok
+	case credentialProxyAuthorization:
+		return basicCredentials(header, "proxy-authorization")
+	case credentialBasicHeader:
+		return basicCredentials(header, s.header)
+	}
+	return basicCredentials(header, "authorization")
+}
+
+// basicCredentials reads the Basic credentials of the header.
+func basicCredentials(header api.RequestHeaderMap, name string) (username, password string, found, ok bool) {
+	auth, found := header.Get(name)
+	if !found {
+		return "", "", false, false
+	}
+	username, password, ok = parseUsernameAndPassword(auth)
+	return username, password, true, ok
+}
+
+// strip removes the credentials which are only meant for the filter from the request.
+func (s *credentialSource) strip(header api.RequestHeaderMap) {
+	switch s.kind {
+	case credentialProxyAuthorization:
+		header.Del("proxy-authorization")
+	case credentialHeaders:
+		header.Del(s.passwordHeader)
+	case credentialBasicHeader:
+		header.Del(s.header)
+	}
+}
+
+// findCredentials reads the credentials from the first source carrying them, the source is nil if none does.
+func (f *filter) findCredentials(header api.RequestHeaderMap) (source *credentialSource, username, password string, ok bool) {
+	sources := f.config.credentialSources
+	if len(sources) == 0 {
+		sources = defaultCredentialSources
+	}
+	for i := range sources {
+		username, password, found, ok := sources[i].credentials(header)
+		if found {
+			return &sources[i], username, password, ok
+		}
+	}
+	// the challenge of the request without credentials is for the first source
+	f.proxyAuth = sources[0].kind == credentialProxyAuthorization
+	return nil, "", "", false
+}
+
+// noCredentialsMessage returns the message of the request without credentials.
+func (c *config) noCredentialsMessage() string {
+	if len(c.credentialSources) == 0 {
+		return "no Authorization"
+	}
+	switch c.credentialSources[0].kind {
+	case credentialAuthorization:
+		return "no Authorization"
+	case credentialProxyAuthorization:
+		return "no Proxy-Authorization"
+	}
+	return "no credentials"
+}
+
+// proxyAuthenticate returns the Proxy-Authenticate challenge of the denied requests.
+func (c *config) proxyAuthenticate() string {
+	realm := c.realm
+	if realm == "" {
+		realm = "Restricted"
+	}
+	return `Basic realm="` + strings.ReplaceAll(realm, `"`, "") + `"`
+}
@@ -0,0 +1,109 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+// The reasons why a request is denied, reported in the logs and the dynamic metadata.
+const (
+	reasonNoCredentials      = "no_credentials"
+	reasonInvalidFormat      = "invalid_format"
+	reasonInvalidCredentials = "invalid_credentials"
+	reasonUserNotFound       = "user_not_found"
+	reasonAmbiguousUser      = "ambiguous_user"
+	reasonNotInGroup         = "not_in_group"
+	reasonAccountLocked      = "account_locked"
+	reasonAccountDisabled    = "account_disabled"
+	reasonAccountExpired     = "account_expired"
+	reasonAccountDenied      = "account_denied"
+	reasonPasswordExpired    = "password_expired"
+	reasonPasswordMustChange = "password_must_change"
+	reasonLogonHours         = "logon_hours"
+	reasonLogonWorkstation   = "logon_workstation"
+	reasonIdentityMismatch   = "identity_mismatch"
+	reasonAccessDenied       = "access_denied"
+	reasonUserDenied         = "user_denied"
+	reasonSourceDenied       = "source_denied"
+	reasonTimeWindow         = "time_window"
+	reasonLDAPError          = "ldap_error"
+)
+
+var failureReasons = map[string]bool{
+	reasonNoCredentials:      true,
+	reasonInvalidFormat:      true,
+	reasonInvalidCredentials: true,
+	reasonUserNotFound:       true,
+	reasonAmbiguousUser:      true,
+	reasonNotInGroup:         true,
+	reasonAccountLocked:      true,
+	reasonAccountDisabled:    true,
+	reasonAccountExpired:     true,
+	reasonAccountDenied:      true,
+	reasonPasswordExpired:    true,
+	reasonPasswordMustChange: true,
+	reasonLogonHours:         true,
+	reasonLogonWorkstation:   true,
+	reasonIdentityMismatch:   true,
+	reasonAccessDenied:       true,
+	reasonUserDenied:         true,
+	reasonSourceDenied:       true,
+	reasonTimeWindow:         true,
+	reasonLDAPError:          true,
+}
+
+// reasonStatus is the default status of the denials which are not 401 Unauthorized,
+// for the authenticated users which are not allowed to access.
+var reasonStatus = map[string]int{
+	reasonAccessDenied: 403,
+	reasonUserDenied:   403,
+	reasonSourceDenied: 403,
+	reasonTimeWindow:   403,
+	reasonLogonHours:   403,
+}
+
+// failureResponse is the response sent when a request is denied for a reason.
+type failureResponse struct {
+	status  int
+	message string
+}
+
+// denial is the local reply sent when the request is denied.
+type denial struct {
+	status  int
+	message string
+	reason  string
+	headers map[string]string
+}
+
+// deny returns the denial for the reason, the response can be configured per reason with failureResponses.
+func (f *filter) deny(reason, message string) *denial {
+	d := &denial{status: 401, message: message, reason: reason, headers: map[string]string{}}
+	if status, ok := reasonStatus[reason]; ok {
+		d.status = status
+	} else if f.proxyAuth {
+		d.status = 407
+		d.headers["proxy-authenticate"] = f.config.proxyAuthenticate()
+	}
+	if resp, ok := f.config.failureResponses[reason]; ok {
+		if resp.status != 0 {
+			d.status = resp.status
+		}
+		if resp.message != "" {
+			d.message = resp.message
+		}
+	}
+	return d
+}
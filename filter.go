@@ -19,12 +19,13 @@ package main
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/base64"
 	"fmt"
 	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
 	"github.com/go-ldap/ldap/v3"
 	"net"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -32,6 +33,21 @@ import (
 type filter struct {
 	callbacks api.FilterCallbackHandler
 	config    *config
+	// deadline of the authentication, zero if there is no requestTimeout
+	deadline time.Time
+	// reason of the authentication failure, if known
+	reason string
+	// when the password of the user expires, zero if unknown
+	passwordExpiresAt time.Time
+	// username as provided in the request, and after the usernameNormalization
+	originalUsername string
+	username         string
+	// whether the credentials are for the proxy, which answers with 407 and Proxy-Authenticate
+	proxyAuth bool
+	// the login form being posted in form login mode, nil otherwise
+	login *loginRequest
+	// the source which authenticated the user, ldap or local
+	authSource string
 }
 
 // parseUsernameAndPassword parses an HTTP Basic Authentication string.
@@ -53,107 +69,200 @@ func parseUsernameAndPassword(auth string) (username, password string, ok bool)
 	return username, password, true
 }
 
-func Connect(conf *config) (*ldap.Conn, error) {
-	var rootCA *x509.CertPool
+// ldapConn is a connection to the LDAP server, closed once the deadline of the request is exceeded.
+type ldapConn struct {
+	*ldap.Conn
+	timer *time.Timer
+}
 
-	if conf.rootCA != "" {
-		rootCA = x509.NewCertPool()
-		rootCA.AppendCertsFromPEM([]byte(conf.rootCA))
+// Close closes the connection and stops its deadline timer.
+func (c *ldapConn) Close() {
+	if c.timer != nil {
+		c.timer.Stop()
 	}
+	c.Conn.Close()
+}
 
-	var conn *ldap.Conn = nil
-	var err error = nil
-	if conf.tls {
-		tlsCfg := &tls.Config{
-			InsecureSkipVerify: conf.insecureSkipVerify,
-			ServerName:         conf.host,
-			RootCAs:            rootCA,
-		}
-		if conf.startTLS {
-			conn, err = dial(conf)
-			if err == nil {
-				err = conn.StartTLS(tlsCfg)
-			}
-		} else {
-			conn, err = dialTLS(conf, tlsCfg)
-		}
-	} else {
-		conn, err = dial(conf)
+// Connect connects to the LDAP server. Once the deadline is exceeded, the connection is closed
+// so that the pending operations fail, a zero deadline means no deadline.
+func Connect(conf *config, deadline time.Time) (*ldapConn, error) {
+	var tlsCfg *tls.Config
+	if conf.tls && !conf.startTLS && conf.socketPath == "" {
+		tlsCfg = newTLSConfig(conf)
 	}
-
+	c, err := dial(conf, tlsCfg, deadline)
 	if err != nil {
 		return nil, err
 	}
+	conn := &ldapConn{Conn: c}
+
+	if conf.operationTimeout > 0 {
+		conn.SetTimeout(conf.operationTimeout)
+	}
+	if !deadline.IsZero() {
+		conn.timer = time.AfterFunc(time.Until(deadline), c.Close)
+	}
+
+	if conf.tls && conf.startTLS && conf.socketPath == "" {
+		err = conn.StartTLS(newTLSConfig(conf))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
 	return conn, nil
 }
 
-func dialTLS(conf *config, tlsCfg *tls.Config) (*ldap.Conn, error) {
-	return ldap.DialURL(
-		fmt.Sprintf("ldaps://%s:%d", conf.host, conf.port),
-		ldap.DialWithTLSDialer(
-			tlsCfg,
-			&net.Dialer{
-				Timeout: time.Duration(conf.timeout) * time.Second,
-			}),
-	)
+// serverURL returns the URL to dial the LDAP server, over TLS if useTLS is true.
+func serverURL(conf *config, useTLS bool) string {
+	switch {
+	case conf.socketPath != "":
+		return (&url.URL{Scheme: "ldapi", Path: conf.socketPath}).String()
+	case useTLS:
+		return fmt.Sprintf("ldaps://%s", net.JoinHostPort(conf.host, strconv.FormatUint(conf.port, 10)))
+	default:
+		return fmt.Sprintf("ldap://%s", net.JoinHostPort(conf.host, strconv.FormatUint(conf.port, 10)))
+	}
 }
 
-func dial(conf *config) (*ldap.Conn, error) {
-	return ldap.DialURL(
-		fmt.Sprintf("ldap://%s:%d", conf.host, conf.port),
-		ldap.DialWithDialer(&net.Dialer{
-			Timeout: time.Duration(conf.timeout) * time.Second,
-		}),
-	)
+// dial connects to the LDAP server, over TLS if tlsCfg is not nil.
+func dial(conf *config, tlsCfg *tls.Config, deadline time.Time) (*ldap.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:  conf.connectTimeout(),
+		Deadline: deadline,
+	}
+	if tlsCfg != nil {
+		return ldap.DialURL(serverURL(conf, true), ldap.DialWithTLSDialer(tlsCfg, dialer))
+	}
+	return ldap.DialURL(serverURL(conf, false), ldap.DialWithDialer(dialer))
 }
 
 // newLdapClient creates a new ldap client.
-func newLdapClient(conf *config) (*ldap.Conn, error) {
-	client, err := Connect(conf)
+func newLdapClient(conf *config, deadline time.Time) (*ldapConn, error) {
+	client, err := Connect(conf, deadline)
 	if err != nil {
 		return nil, err
 	}
 
 	// First bind with a read only user
-	err = client.Bind(conf.bindDN, conf.password)
+	switch conf.serviceBindMethod() {
+	case bindMethodExternal:
+		// the identity is taken from the TLS client certificate
+		err = client.ExternalBind()
+	case bindMethodAnonymous:
+		_, err = client.SimpleBind(&ldap.SimpleBindRequest{
+			AllowEmptyPassword: true,
+		})
+	default:
+		err = client.Bind(conf.bindDN, conf.password)
+	}
 	if err != nil {
+		client.Close()
 		return nil, err
 	}
 	return client, nil
 }
 
-// authLdap authenticates the user against the ldap server.
-func (f *filter) authLdap(username, password string) bool {
-	if f.config.filter != "" {
+// authLdap authenticates the user against the ldap servers, it returns nil if the authentication fails.
+func (f *filter) authLdap(username, password string) (*ldapUser, authResult) {
+	if len(f.config.backends) > 0 {
+		user, result := f.authBackends(username, password)
+		if user == nil {
+			return nil, result
+		}
+		// the requiredGroups of the filter, or of the route, apply on top of the ones of the backend
+		return f.checkRequiredGroups(user)
+	}
+	return f.authBackend(f.config, username, password)
+}
+
+// authBackend authenticates the user against the ldap server of the config.
+func (f *filter) authBackend(conf *config, username, password string) (*ldapUser, authResult) {
+	user, result := f.authMode(conf, username, password)
+	if result == authFailed {
+		// the server failed or is unreachable, whatever the operation
+		f.reason = reasonLDAPError
+	}
+	if user != nil {
+		user.backend, user.source = conf, authSourceLDAP
+	}
+	return user, result
+}
+
+// authMode authenticates the user in the mode of the config.
+func (f *filter) authMode(conf *config, username, password string) (*ldapUser, authResult) {
+	switch conf.authMode() {
+	case modeSearch:
 		f.callbacks.Log(api.Debug, "running in search mode")
-		return f.searchMode(username, password)
+		return f.searchMode(conf, username, password)
+	case modeCompare:
+		f.callbacks.Log(api.Debug, "running in compare mode")
+		return f.compareMode(conf, username, password)
 	}
 
 	// run with bind mode
 	f.callbacks.Log(api.Debug, "running in bind mode")
+	return f.bindMode(conf, username, password)
+}
+
+func (f *filter) bindMode(conf *config, username, password string) (*ldapUser, authResult) {
+	userDN, err := bindName(conf, username)
+	if err != nil {
+		f.callbacks.Log(api.Debug, err.Error())
+		return nil, authUnidentified
+	}
 
-	client, err := Connect(f.config)
+	client, err := Connect(conf, f.deadline)
 	if err != nil {
 		f.callbacks.Log(api.Error, fmt.Sprintf("dial error: %v", err))
-		return false
+		return nil, authFailed
 	}
+	defer client.Close()
 
-	userDN := fmt.Sprintf("%s=%s,%s", f.config.attribute, username, f.config.baseDN)
 	f.callbacks.Log(api.Debug, fmt.Sprintf("Authenticating User: %s", userDN))
 
 	// SimpleBind User and password.
-	_, err = client.SimpleBind(&ldap.SimpleBindRequest{
-		Username: userDN,
-		Password: password,
-	})
-	return err == nil
+	err = f.bindUser(client, userDN, password)
+	if err != nil {
+		f.callbacks.Log(api.Debug, fmt.Sprintf("bind error: %v", err))
+		switch f.reason {
+		case reasonInvalidCredentials, reasonUserNotFound:
+			// in bind mode, an unknown user can't be told from a wrong password
+			return nil, authUnidentified
+		case reasonLDAPError:
+			return nil, authFailed
+		}
+		return nil, authDenied
+	}
+
+	user := &ldapUser{dn: userDN}
+	if conf.whoAmI {
+		boundDN := userDN
+		if conf.bindFormat == bindFormatUPN || conf.bindFormat == bindFormatNetBIOS {
+			boundDN = ""
+		}
+		if result := f.verifyIdentity(client, conf, user, boundDN); result != authSucceeded {
+			return nil, result
+		}
+	}
+	if user.entry == nil && len(conf.requiredAttributes()) > 0 {
+		user.entry, err = f.lookupBoundUser(client, conf, username, userDN)
+		if err != nil {
+			f.callbacks.Log(api.Error, fmt.Sprintf("user lookup error: %v", err))
+			return nil, authFailed
+		}
+		if !f.checkAccountState(conf, user.entry) {
+			return nil, authDenied
+		}
+	}
+	return f.checkGroups(conf, user)
 }
 
-func (f *filter) searchMode(username, password string) (auth bool) {
-	client, err := newLdapClient(f.config)
+func (f *filter) searchMode(conf *config, username, password string) (user *ldapUser, result authResult) {
+	client, err := newLdapClient(conf, f.deadline)
 	if err != nil {
 		f.callbacks.Log(api.Error, fmt.Sprintf("newLdapClient error: %v", err))
-		return
+		return nil, authFailed
 	}
 	defer func() {
 		if client != nil {
@@ -161,80 +270,271 @@ func (f *filter) searchMode(username, password string) (auth bool) {
 		}
 		err := recover()
 		if err != nil {
-			auth = false
+			user, result = nil, authFailed
 			return
 		}
 	}()
 
-	req := ldap.NewSearchRequest(
-		f.config.baseDN,
-		ldap.ScopeWholeSubtree,
-		ldap.NeverDerefAliases,
-		0,
-		0,
-		false,
-		fmt.Sprintf(f.config.filter, username),
-		[]string{"dn", "cn"}, nil)
-
-	sr, err := client.Search(req)
-	if err != nil {
-		f.callbacks.Log(api.Error, fmt.Sprintf("search error: %v", err))
-		return
+	entry, result := f.findUser(client, conf, username)
+	if entry == nil {
+		return nil, result
 	}
 
-	switch {
-	case len(sr.Entries) < 1:
-		f.callbacks.Log(api.Debug, "search filter return empty result")
-		return
-	case len(sr.Entries) > 1:
-		f.callbacks.Log(api.Debug, fmt.Sprintf("search filter return multiple entries (%d)", len(sr.Entries)))
-		return
+	if !f.checkAccountState(conf, entry.Entry) {
+		return nil, authDenied
 	}
 
-	userDN := sr.Entries[0].DN
+	userDN := entry.DN
 	f.callbacks.Log(api.Debug, fmt.Sprintf("authenticating user: %s", userDN))
 
+	// the user found by following a referral binds to the server holding its entry
+	bindClient := client
+	if entry.server != conf {
+		bindClient, err = Connect(entry.server, f.deadline)
+		if err != nil {
+			f.callbacks.Log(api.Error, fmt.Sprintf("dial error: %v", err))
+			return nil, authFailed
+		}
+		defer bindClient.Close()
+	}
+
 	// Bind User and password.
-	err = client.Bind(userDN, password)
+	err = f.bindUser(bindClient, userDN, password)
 	if err != nil {
 		f.callbacks.Log(api.Debug, fmt.Sprintf("bind error: %v", err))
-		return
+		if f.reason == reasonLDAPError {
+			// the directory failed, it doesn't tell whether the password is wrong
+			return nil, authFailed
+		}
+		return nil, authDenied
 	}
 
-	auth = true
-	return
+	user = &ldapUser{dn: userDN, entry: entry.Entry}
+	if conf.whoAmI {
+		if result := f.verifyIdentity(bindClient, conf, user, userDN); result != authSucceeded {
+			return nil, result
+		}
+	}
+	return f.checkGroups(conf, user)
 }
 
-func (f *filter) verify(header api.RequestHeaderMap) (bool, string) {
-	auth, ok := header.Get("authorization")
-	if !ok {
-		return false, "no Authorization"
+// compareMode verifies the password with a compare operation of the service account,
+// for the directories which don't allow the users to bind.
+func (f *filter) compareMode(conf *config, username, password string) (*ldapUser, authResult) {
+	if password == "" {
+		// an empty value never matches, but some servers treat it as a presence check
+		return nil, authDenied
 	}
 
-	username, password, ok := parseUsernameAndPassword(auth)
+	client, err := newLdapClient(conf, f.deadline)
+	if err != nil {
+		f.callbacks.Log(api.Error, fmt.Sprintf("newLdapClient error: %v", err))
+		return nil, authFailed
+	}
+	defer client.Close()
+
+	user := &ldapUser{}
+	if conf.hasSearchFilter() {
+		entry, result := f.findUser(client, conf, username)
+		if entry == nil {
+			return nil, result
+		}
+		if !f.checkAccountState(conf, entry.Entry) {
+			return nil, authDenied
+		}
+		user.dn, user.entry = entry.DN, entry.Entry
+		// the user found by following a referral is compared on the server holding its entry
+		if entry.server != conf {
+			referred, err := newLdapClient(entry.server, f.deadline)
+			if err != nil {
+				f.callbacks.Log(api.Error, fmt.Sprintf("newLdapClient error: %v", err))
+				return nil, authFailed
+			}
+			defer referred.Close()
+			client = referred
+		}
+	} else {
+		user.dn, err = bindName(conf, username)
+		if err != nil {
+			f.callbacks.Log(api.Debug, err.Error())
+			return nil, authUnidentified
+		}
+	}
+	f.callbacks.Log(api.Debug, fmt.Sprintf("comparing password of user: %s", user.dn))
+
+	ok, err := client.Compare(user.dn, conf.compareAttr(), password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		f.callbacks.Log(api.Debug, fmt.Sprintf("user %s not found", user.dn))
+		f.reason = reasonUserNotFound
+		return nil, authUnidentified
+	}
+	if err != nil {
+		f.callbacks.Log(api.Error, fmt.Sprintf("compare error: %v", err))
+		return nil, authFailed
+	}
 	if !ok {
-		return false, "invalid Authorization format"
+		f.callbacks.Log(api.Debug, fmt.Sprintf("password of user %s does not match", user.dn))
+		f.reason = reasonInvalidCredentials
+		return nil, authDenied
+	}
+
+	if user.entry == nil && len(conf.requiredAttributes()) > 0 {
+		user.entry, err = f.lookupBoundUser(client, conf, username, user.dn)
+		if err != nil {
+			f.callbacks.Log(api.Error, fmt.Sprintf("user lookup error: %v", err))
+			return nil, authFailed
+		}
+		if !f.checkAccountState(conf, user.entry) {
+			return nil, authDenied
+		}
 	}
-	ok = f.authLdap(username, password)
+	return f.checkGroups(conf, user)
+}
+
+// verify authenticates the request, it returns nil if the request is allowed.
+func (f *filter) verify(header api.RequestHeaderMap) *denial {
+	if f.config.passwordExpiryHeader != "" {
+		header.Del(f.config.passwordExpiryHeader)
+	}
+	if f.config.identityHeader != "" {
+		header.Del(f.config.identityHeader)
+	}
+	if f.config.authSourceHeader != "" {
+		header.Del(f.config.authSourceHeader)
+	}
+
+	source, username, password, ok := f.findCredentials(header)
+	if source == nil {
+		return f.deny(reasonNoCredentials, f.config.noCredentialsMessage())
+	}
+	f.proxyAuth = source.kind == credentialProxyAuthorization
 	if !ok {
-		return false, "invalid username or password"
+		return f.deny(reasonInvalidFormat, "invalid Authorization format")
 	}
-	return true, ""
+
+	user, d := f.authenticate(username, password)
+	if d != nil {
+		return d
+	}
+	f.callbacks.Log(api.Debug, fmt.Sprintf("user %s authenticated", user.name()))
+	f.authSource = user.source
+	if d := f.authorize(header, user); d != nil {
+		return d
+	}
+	if f.config.identityHeader != "" {
+		header.Set(f.config.identityHeader, user.name())
+	}
+	if f.config.authSourceHeader != "" {
+		header.Set(f.config.authSourceHeader, f.authSource)
+	}
+	source.strip(header)
+	f.setPasswordExpiryHeader(header)
+	return nil
+}
+
+// authenticate normalizes the username and authenticates the user against the ldap servers.
+func (f *filter) authenticate(username, password string) (*ldapUser, *denial) {
+	f.originalUsername, f.username = username, username
+	if f.config.normalization != nil {
+		normalized, err := f.config.normalization.normalize(username)
+		if err != nil {
+			f.callbacks.Log(api.Debug, fmt.Sprintf("invalid username %q: %v", username, err))
+			return nil, f.deny(reasonInvalidFormat, "invalid username")
+		}
+		f.callbacks.Log(api.Debug, fmt.Sprintf("username %q normalized to %q", username, normalized))
+		f.username = normalized
+	}
+
+	// the blocked accounts don't even reach the LDAP server
+	if d := f.checkDenylist(f.username, f.originalUsername); d != nil {
+		return nil, d
+	}
+
+	if f.config.localUsers != nil && f.config.localUsersMode == localUsersFirst {
+		if user := f.authLocal(f.username, password); user != nil {
+			return user, nil
+		}
+	}
+	user, result := f.authLdap(f.username, password)
+	if user == nil && result == authFailed && f.config.localUsers != nil && f.config.localUsersMode != localUsersFirst {
+		// the directory is unavailable
+		user = f.authLocal(f.username, password)
+	}
+	if user == nil {
+		reason := f.reason
+		if reason == "" {
+			reason = reasonInvalidCredentials
+		}
+		return nil, f.deny(reason, "invalid username or password")
+	}
+	return user, nil
+}
+
+// deadlineExceeded returns true if the authentication took longer than the requestTimeout.
+func (f *filter) deadlineExceeded() bool {
+	return !f.deadline.IsZero() && time.Now().After(f.deadline)
 }
 
 func (f *filter) DecodeHeaders(header api.RequestHeaderMap, endStream bool) api.StatusType {
+	if f.config.requestTimeout > 0 {
+		f.deadline = time.Now().Add(f.config.requestTimeout)
+	}
+	if f.sendDenial(f.checkSource(header)) {
+		return api.LocalReply
+	}
+	if f.config.revokePath != "" {
+		if path, _, _ := strings.Cut(header.Path(), "?"); path == f.config.revokePath {
+			go f.revokeSessions(header)
+			return api.Running
+		}
+	}
+	if f.config.formLogin != nil {
+		if status, handled := f.decodeFormLogin(header, endStream); handled {
+			return status
+		}
+	}
 	go func() {
-		if ok, msg := f.verify(header); !ok {
-			// TODO: set the WWW-Authenticate response header
-			f.callbacks.SendLocalReply(401, msg, map[string]string{}, 0, "bad-request")
-			return
+		if !f.sendDenial(f.verify(header)) {
+			f.callbacks.Continue(api.Continue)
 		}
-		f.callbacks.Continue(api.Continue)
 	}()
 	return api.Running
 }
 
+// sendDenial replies to the request if it is denied or the authentication timed out,
+// it returns false if the request is allowed.
+func (f *filter) sendDenial(d *denial) bool {
+	// the user may still be authenticated by the local users once the directory timed out
+	if d != nil && f.deadlineExceeded() {
+		f.callbacks.Log(api.Warn, fmt.Sprintf("authentication exceeded the request timeout of %v", f.config.requestTimeout))
+		f.callbacks.SendLocalReply(f.config.timeoutStatus(), f.config.timeoutMessage(), map[string]string{}, 0, "ldap-timeout")
+		return true
+	}
+	if f.username != "" {
+		f.callbacks.StreamInfo().DynamicMetadata().Set(pluginName, "username", f.username)
+		f.callbacks.StreamInfo().DynamicMetadata().Set(pluginName, "original_username", f.originalUsername)
+	}
+	if d == nil {
+		if f.authSource != "" {
+			f.callbacks.StreamInfo().DynamicMetadata().Set(pluginName, "auth_source", f.authSource)
+		}
+		return false
+	}
+	if f.username != "" {
+		f.callbacks.Log(api.Info, fmt.Sprintf("request denied: %s (username %q, provided as %q)", d.reason, f.username, f.originalUsername))
+	} else {
+		f.callbacks.Log(api.Info, fmt.Sprintf("request denied: %s", d.reason))
+	}
+	f.callbacks.StreamInfo().DynamicMetadata().Set(pluginName, "failure_reason", d.reason)
+	// TODO: set the WWW-Authenticate response header
+	f.callbacks.SendLocalReply(d.status, d.message, d.headers, 0, "bad-request")
+	return true
+}
+
 func (f *filter) DecodeData(buffer api.BufferInstance, endStream bool) api.StatusType {
+	if f.login != nil {
+		return f.decodeLoginForm(buffer, endStream)
+	}
 	return api.Continue
 }
 
@@ -0,0 +1,377 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultLoginPage = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<form method="post" action="{{.Action}}">
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="hidden" name="return" value="{{.Return}}">
+<p><label>Username <input type="text" name="username" autocomplete="username" required autofocus></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+<p><button type="submit">Sign in</button></p>
+</form>
+</body>
+</html>
+`
+
+// formLogin is the config of the form login mode, where the browsers log in with an HTML form
+// and are then authenticated by a session cookie.
+type formLogin struct {
+	loginPath      string
+	logoutPath     string
+	page           *template.Template
+	redirect       bool
+	cookieName     string
+	sessionTimeout time.Duration
+	insecureCookie bool
+}
+
+// loginPage is the data of the login page template.
+type loginPage struct {
+	Action    string
+	CSRFToken string
+	Return    string
+	Error     string
+}
+
+// loginRequest is a login form being posted.
+type loginRequest struct {
+	csrfCookie string
+}
+
+// parseFormLogin parses the formLogin option.
+func parseFormLogin(v interface{}) (*formLogin, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid formLogin: should be an object")
+	}
+	fl := &formLogin{
+		loginPath:      "/login",
+		logoutPath:     "/logout",
+		cookieName:     "ldap_session",
+		sessionTimeout: time.Hour,
+	}
+	if loginPath, ok := m["loginPath"].(string); ok && loginPath != "" {
+		if !strings.HasPrefix(loginPath, "/") {
+			return nil, fmt.Errorf("invalid formLogin: loginPath %s should start with /", loginPath)
+		}
+		fl.loginPath = loginPath
+	}
+	if logoutPath, ok := m["logoutPath"].(string); ok && logoutPath != "" {
+		if !strings.HasPrefix(logoutPath, "/") {
+			return nil, fmt.Errorf("invalid formLogin: logoutPath %s should start with /", logoutPath)
+		}
+		fl.logoutPath = logoutPath
+	}
+	if fl.logoutPath == fl.loginPath {
+		return nil, errors.New("invalid formLogin: logoutPath and loginPath should differ")
+	}
+	page := defaultLoginPage
+	if loginPage, ok := m["loginPage"].(string); ok && loginPage != "" {
+		page = loginPage
+	}
+	tmpl, err := template.New("login").Parse(page)
+	if err != nil {
+		return nil, fmt.Errorf("invalid formLogin: loginPage: %w", err)
+	}
+	fl.page = tmpl
+	fl.redirect, _ = m["redirect"].(bool)
+	if cookieName, ok := m["cookieName"].(string); ok && cookieName != "" {
+		fl.cookieName = cookieName
+	}
+	if sessionTimeout, ok := m["sessionTimeout"].(float64); ok {
+		if sessionTimeout <= 0 {
+			return nil, errors.New("invalid formLogin: sessionTimeout must be positive")
+		}
+		fl.sessionTimeout = time.Duration(sessionTimeout * float64(time.Second))
+	}
+	fl.insecureCookie, _ = m["insecureCookie"].(bool)
+	return fl, nil
+}
+
+// csrfCookieName returns the name of the cookie holding the CSRF token of the login form.
+func (fl *formLogin) csrfCookieName() string {
+	return fl.cookieName + "_csrf"
+}
+
+// cookie returns the Set-Cookie header value of the cookie.
+func (fl *formLogin) cookie(name, value, path string, maxAge time.Duration) string {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   !fl.insecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	}
+	return c.String()
+}
+
+// cookieValue returns the value of the cookie of the request, empty if not present.
+func cookieValue(header api.RequestHeaderMap, name string) string {
+	req := &http.Request{Header: http.Header{"Cookie": header.Values("cookie")}}
+	c, err := req.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+// returnPath returns the local path to return to after the login, the other URLs are
+// rejected so that the login can't redirect to another site. The browsers remove the tabs
+// and newlines of the URLs, so the control characters are rejected as well.
+func returnPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, `/\`) {
+		return "/"
+	}
+	if strings.IndexFunc(path, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0 {
+		return "/"
+	}
+	u, err := url.Parse(path)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return "/"
+	}
+	return path
+}
+
+// renderLoginPage renders the login page with a new CSRF token, the token is also set in a cookie
+// which is checked against the form when it is posted.
+func (f *filter) renderLoginPage(returnTo, csrfToken, message string) (string, map[string]string, error) {
+	fl := f.config.formLogin
+	headers := map[string]string{"content-type": "text/html; charset=utf-8", "cache-control": "no-store"}
+	if csrfToken == "" {
+		token, err := randomToken()
+		if err != nil {
+			return "", nil, err
+		}
+		csrfToken = token
+		headers["set-cookie"] = fl.cookie(fl.csrfCookieName(), csrfToken, fl.loginPath, fl.sessionTimeout)
+	}
+	var body bytes.Buffer
+	err := fl.page.Execute(&body, &loginPage{
+		Action:    fl.loginPath,
+		CSRFToken: csrfToken,
+		Return:    returnPath(returnTo),
+		Error:     message,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return body.String(), headers, nil
+}
+
+// sendLoginPage answers with the login page, or a redirect to it.
+func (f *filter) sendLoginPage(header api.RequestHeaderMap, returnTo string) api.StatusType {
+	fl := f.config.formLogin
+	path, _, _ := strings.Cut(header.Path(), "?")
+	if fl.redirect && path != fl.loginPath {
+		location := fl.loginPath + "?" + url.Values{"return": {returnPath(returnTo)}}.Encode()
+		f.callbacks.SendLocalReply(http.StatusFound, "", map[string]string{"location": location}, 0, "login-redirect")
+		return api.LocalReply
+	}
+	body, headers, err := f.renderLoginPage(returnTo, "", "")
+	if err != nil {
+		f.callbacks.Log(api.Error, fmt.Sprintf("login page error: %v", err))
+		f.callbacks.SendLocalReply(http.StatusInternalServerError, "login page error", map[string]string{}, 0, "login-page")
+		return api.LocalReply
+	}
+	status := http.StatusUnauthorized
+	if path == fl.loginPath {
+		status = http.StatusOK
+	}
+	f.callbacks.SendLocalReply(status, body, headers, 0, "login-page")
+	return api.LocalReply
+}
+
+// decodeFormLogin handles the requests of the form login mode: the login page, the posted login form
+// and the requests authenticated by a session cookie. It returns false if the request is to be
+// authenticated with its credentials instead.
+func (f *filter) decodeFormLogin(header api.RequestHeaderMap, endStream bool) (api.StatusType, bool) {
+	fl := f.config.formLogin
+	path, query, _ := strings.Cut(header.Path(), "?")
+	method := header.Method()
+
+	if path == fl.loginPath {
+		switch method {
+		case http.MethodGet, http.MethodHead:
+			values, _ := url.ParseQuery(query)
+			return f.sendLoginPage(header, values.Get("return")), true
+		case http.MethodPost:
+			if endStream {
+				f.callbacks.SendLocalReply(http.StatusBadRequest, "empty login form", map[string]string{}, 0, "bad-request")
+				return api.LocalReply, true
+			}
+			f.login = &loginRequest{csrfCookie: cookieValue(header, fl.csrfCookieName())}
+			return api.StopAndBuffer, true
+		}
+		f.callbacks.SendLocalReply(http.StatusMethodNotAllowed, "method not allowed", map[string]string{"allow": "GET, HEAD, POST"}, 0, "bad-request")
+		return api.LocalReply, true
+	}
+
+	if path == fl.logoutPath {
+		return f.logout(header), true
+	}
+
+	if f.config.passwordExpiryHeader != "" {
+		header.Del(f.config.passwordExpiryHeader)
+	}
+	if f.config.identityHeader != "" {
+		header.Del(f.config.identityHeader)
+	}
+	if f.config.authSourceHeader != "" {
+		header.Del(f.config.authSourceHeader)
+	}
+	if id := cookieValue(header, fl.cookieName); id != "" {
+		if sess := sessions.get(id); sess != nil {
+			f.username, f.originalUsername = sess.username, sess.username
+			f.authSource = sess.user.source
+			d := f.checkSession(sess)
+			if d == nil {
+				d = f.authorize(header, sess.user)
+			}
+			if f.sendDenial(d) {
+				return api.LocalReply, true
+			}
+			if f.config.identityHeader != "" {
+				header.Set(f.config.identityHeader, sess.user.name())
+			}
+			if f.config.authSourceHeader != "" {
+				header.Set(f.config.authSourceHeader, sess.user.source)
+			}
+			return api.Continue, true
+		}
+	}
+
+	// the requests with credentials, e.g. of the API clients, are authenticated as usual
+	if source, _, _, _ := f.findCredentials(header); source == nil && (method == http.MethodGet || method == http.MethodHead) {
+		return f.sendLoginPage(header, header.Path()), true
+	}
+	return 0, false
+}
+
+// checkSession checks the session against the requirements of the config. The session is shared by
+// all the routes, whose requiredGroups and attributes may differ from the ones of the route it was
+// created on, so it is denied if the attributes the config reads weren't fetched at the login.
+func (f *filter) checkSession(sess *session) *denial {
+	user := sess.user
+	needed := f.config.authzAttributes
+	if f.config.authzGroups || len(f.config.requiredGroups) > 0 {
+		groupAttr := f.config.groupAttr()
+		if user.backend != nil {
+			groupAttr = user.backend.groupAttr()
+		}
+		needed = append(needed[:len(needed):len(needed)], groupAttr)
+	}
+	for _, attr := range needed {
+		if !containsFold(sess.attributes, attr) {
+			f.callbacks.Log(api.Info, fmt.Sprintf("session of user %s lacks the attribute %s required by the route", user.name(), attr))
+			return f.deny(reasonAccessDenied, "access denied")
+		}
+	}
+	if _, result := f.checkRequiredGroups(user); result != authSucceeded {
+		return f.deny(f.reason, "access denied")
+	}
+	return nil
+}
+
+// decodeLoginForm authenticates the user with the posted login form. On success, a session is
+// created and the browser is redirected to the page it came from with the session cookie.
+func (f *filter) decodeLoginForm(buffer api.BufferInstance, endStream bool) api.StatusType {
+	if !endStream {
+		return api.StopAndBuffer
+	}
+	fl := f.config.formLogin
+	form, err := url.ParseQuery(buffer.String())
+	if err != nil {
+		f.callbacks.SendLocalReply(http.StatusBadRequest, "invalid login form", map[string]string{}, 0, "bad-request")
+		return api.LocalReply
+	}
+	token := form.Get("csrf_token")
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(f.login.csrfCookie)) != 1 {
+		f.callbacks.Log(api.Info, "login form denied: invalid CSRF token")
+		f.callbacks.SendLocalReply(http.StatusForbidden, "invalid CSRF token", map[string]string{}, 0, "bad-request")
+		return api.LocalReply
+	}
+	returnTo := returnPath(form.Get("return"))
+
+	go func() {
+		user, d := f.authenticate(form.Get("username"), form.Get("password"))
+		if d != nil {
+			body, headers, err := f.renderLoginPage(returnTo, token, d.message)
+			if err == nil {
+				d.message = body
+				for k, v := range headers {
+					d.headers[k] = v
+				}
+			}
+		}
+		if f.sendDenial(d) {
+			return
+		}
+
+		id, err := sessions.create(f.username, user, fl.sessionTimeout)
+		if err != nil {
+			f.callbacks.Log(api.Error, fmt.Sprintf("session error: %v", err))
+			f.callbacks.SendLocalReply(http.StatusInternalServerError, "session error", map[string]string{}, 0, "login-session")
+			return
+		}
+		f.callbacks.Log(api.Info, fmt.Sprintf("user %s logged in", user.name()))
+		f.callbacks.SendLocalReply(http.StatusFound, "", map[string]string{
+			"location":   returnTo,
+			"set-cookie": fl.cookie(fl.cookieName, id, "/", fl.sessionTimeout),
+		}, 0, "login")
+	}()
+	return api.Running
+}
+
+// logout deletes all the sessions of the user of the request, clears the session cookie and
+// redirects to the login page.
+func (f *filter) logout(header api.RequestHeaderMap) api.StatusType {
+	fl := f.config.formLogin
+	if id := cookieValue(header, fl.cookieName); id != "" {
+		if sess := sessions.get(id); sess != nil {
+			n := sessions.revoke(sess.username)
+			f.callbacks.Log(api.Info, fmt.Sprintf("user %s logged out, %d sessions deleted", sess.user.name(), n))
+		}
+		sessions.delete(id)
+	}
+	f.callbacks.SendLocalReply(http.StatusFound, "", map[string]string{
+		"location":      fl.loginPath,
+		"set-cookie":    fl.cookie(fl.cookieName, "", "/", -time.Second),
+		"cache-control": "no-store",
+	}, 0, "logout")
+	return api.LocalReply
+}
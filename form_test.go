@@ -0,0 +1,99 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestReturnPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "/"},
+		{"/app/page?x=1#top", "/app/page?x=1#top"},
+		{"", "/"},
+		{"app", "/"},
+		{"https://evil.com/", "/"},
+		{"//evil.com", "/"},
+		{`/\evil.com`, "/"},
+		{"/\t/evil.com", "/"},
+		{"/\n/evil.com", "/"},
+		{"/\r\n/evil.com", "/"},
+		{"/\x00/evil.com", "/"},
+		{"/\x7f/evil.com", "/"},
+		{"/%zz", "/"},
+	}
+	for _, tt := range tests {
+		if got := returnPath(tt.path); got != tt.want {
+			t.Errorf("returnPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCheckSession(t *testing.T) {
+	admins := "cn=admins,ou=groups,dc=example,dc=com"
+	// the session was created on a route without requirements, the entry has no groups
+	login := &config{}
+	user := &ldapUser{dn: "cn=bob,dc=example,dc=com", backend: login, entry: ldap.NewEntry("cn=bob,dc=example,dc=com", nil)}
+	sess := &session{username: "bob", user: user, attributes: login.requiredAttributes()}
+
+	f := &filter{callbacks: &testCallbacks{}, config: &config{}}
+	if d := f.checkSession(sess); d != nil {
+		t.Errorf("the session should be allowed on a route without requirements: %v", d.reason)
+	}
+
+	f.config = &config{requiredGroups: []string{admins}}
+	if d := f.checkSession(sess); d == nil {
+		t.Errorf("the session should be denied on a route requiring groups which weren't fetched")
+	}
+
+	rule, err := parseAccessRule(`attr.employeeType != "contractor"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.config = &config{accessRules: []*accessRule{rule}}
+	f.config.updateAuthzAttributes()
+	if d := f.checkSession(sess); d == nil {
+		t.Errorf("the session should be denied on a route reading attributes which weren't fetched")
+	}
+
+	// the session was created on a route requiring the groups, with the groups fetched
+	login = &config{requiredGroups: []string{admins}}
+	member := &ldapUser{dn: "cn=alice,dc=example,dc=com", backend: login, entry: ldap.NewEntry("cn=alice,dc=example,dc=com", map[string][]string{
+		"memberOf": {admins},
+	})}
+	f.config = &config{requiredGroups: []string{admins}}
+	if d := f.checkSession(&session{username: "alice", user: member, attributes: login.requiredAttributes()}); d != nil {
+		t.Errorf("the member should be allowed: %v", d.reason)
+	}
+	f.config = &config{requiredGroups: []string{"cn=other,ou=groups,dc=example,dc=com"}}
+	if d := f.checkSession(&session{username: "alice", user: member, attributes: login.requiredAttributes()}); d == nil || d.reason != reasonNotInGroup {
+		t.Errorf("the session should be denied on a route requiring other groups")
+	}
+
+	// the local users have no groups
+	local := &ldapUser{dn: "breakglass", source: authSourceLocal}
+	f.config = &config{requiredGroups: []string{admins}}
+	if d := f.checkSession(&session{username: "breakglass", user: local}); d == nil {
+		t.Errorf("the local user should be denied on a route requiring groups")
+	}
+}
@@ -6,6 +6,8 @@ require (
 	github.com/cncf/xds/go v0.0.0-20230428030218-4003588d1b74
 	github.com/envoyproxy/envoy v1.26.1
 	github.com/go-ldap/ldap/v3 v3.4.4
+	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d
+	golang.org/x/text v0.7.0
 	google.golang.org/protobuf v1.30.0
 )
 
@@ -14,10 +16,8 @@ require (
 	github.com/envoyproxy/protoc-gen-validate v0.1.0 // indirect
 	github.com/go-asn1-ber/asn1-ber v1.5.4 // indirect
 	github.com/golang/protobuf v1.5.0 // indirect
-	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d // indirect
 	golang.org/x/net v0.7.0 // indirect
 	golang.org/x/sys v0.5.0 // indirect
-	golang.org/x/text v0.7.0 // indirect
 	google.golang.org/genproto v0.0.0-20190819201941-24fa4b261c55 // indirect
 	google.golang.org/grpc v1.25.1 // indirect
 )
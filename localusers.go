@@ -0,0 +1,176 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// When the local users are consulted.
+const (
+	localUsersFirst    = "first"
+	localUsersFallback = "fallback"
+)
+
+// The sources the users are authenticated by, reported in the authSourceHeader and the dynamic metadata.
+const (
+	authSourceLDAP  = "ldap"
+	authSourceLocal = "local"
+)
+
+// localUser is a break-glass local user, with either a bcrypt or an argon2 hash.
+type localUser struct {
+	bcrypt []byte
+	argon2 *argon2Hash
+}
+
+// parseHtpasswd parses an htpasswd file of users with bcrypt or argon2 hashes.
+// The hashes are checked when the file is loaded, so that a bad hash never reaches the requests.
+func parseHtpasswd(data []byte) (interface{}, error) {
+	users := make(map[string]*localUser)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("line %d: should be username:hash", n)
+		}
+		user := &localUser{}
+		switch {
+		case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+			if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+				return nil, fmt.Errorf("line %d: invalid bcrypt hash of %s: %v", n, username, err)
+			}
+			user.bcrypt = []byte(hash)
+		case strings.HasPrefix(hash, "$argon2id$"), strings.HasPrefix(hash, "$argon2i$"):
+			h, err := parseArgon2(hash)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid hash of %s: %v", n, username, err)
+			}
+			user.argon2 = h
+		default:
+			return nil, fmt.Errorf("line %d: unsupported hash of %s, should be bcrypt or argon2", n, username)
+		}
+		users[username] = user
+	}
+	return users, scanner.Err()
+}
+
+// The bounds of the argon2 parameters, so that a bad hash can't exhaust the memory or the CPU.
+const (
+	argon2MaxMemory = 256 * 1024 // KiB
+	argon2MaxTime   = 16
+	argon2MinSalt   = 8
+	argon2MinKey    = 16
+	argon2MaxKey    = 64
+)
+
+// argon2Hash is an argon2 hash in the PHC format: $argon2id$v=19$m=65536,t=3,p=4$salt$hash.
+type argon2Hash struct {
+	id      bool
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2 parses an argon2 hash and checks its parameters are within bounds.
+func parseArgon2(hash string) (*argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || (parts[1] != "argon2id" && parts[1] != "argon2i") {
+		return nil, errors.New("invalid argon2 hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, errors.New("unsupported argon2 version")
+	}
+	h := &argon2Hash{id: parts[1] == "argon2id"}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, errors.New("invalid argon2 parameters")
+	}
+	if h.time == 0 || h.time > argon2MaxTime {
+		return nil, fmt.Errorf("argon2 time should be between 1 and %d", argon2MaxTime)
+	}
+	if h.threads == 0 {
+		return nil, errors.New("argon2 parallelism should be at least 1")
+	}
+	if h.memory < 8*uint32(h.threads) || h.memory > argon2MaxMemory {
+		return nil, fmt.Errorf("argon2 memory should be between 8*p and %d KiB", argon2MaxMemory)
+	}
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(h.salt) < argon2MinSalt {
+		return nil, errors.New("invalid argon2 salt")
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) < argon2MinKey || len(h.key) > argon2MaxKey {
+		return nil, errors.New("invalid argon2 hash")
+	}
+	return h, nil
+}
+
+// verify verifies the password against the hash.
+func (h *argon2Hash) verify(password string) bool {
+	var actual []byte
+	if h.id {
+		actual = argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	} else {
+		actual = argon2.Key([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	}
+	return subtle.ConstantTimeCompare(actual, h.key) == 1
+}
+
+// verify verifies the password of the local user.
+func (u *localUser) verify(password string) bool {
+	if u.argon2 != nil {
+		return u.argon2.verify(password)
+	}
+	return bcrypt.CompareHashAndPassword(u.bcrypt, []byte(password)) == nil
+}
+
+// authLocal authenticates the user against the break-glass local users, it returns nil if the
+// authentication fails or if groups are required. Its use is always logged, for the audit.
+func (f *filter) authLocal(username, password string) *ldapUser {
+	users := f.config.localUsers.get().(map[string]*localUser)
+	user, ok := users[username]
+	if !ok {
+		return nil
+	}
+	if !user.verify(password) {
+		f.callbacks.Log(api.Warn, fmt.Sprintf("break-glass: authentication of local user %s failed", username))
+		return nil
+	}
+	f.callbacks.Log(api.Warn, fmt.Sprintf("break-glass: local user %s authenticated", username))
+	// the local users have no groups, so they never satisfy the requiredGroups
+	local, result := f.checkRequiredGroups(&ldapUser{dn: username, source: authSourceLocal})
+	if result != authSucceeded {
+		f.callbacks.Log(api.Warn, fmt.Sprintf("break-glass: local user %s denied by the required groups", username))
+	}
+	return local
+}
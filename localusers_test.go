@@ -0,0 +1,97 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func argon2Line(username, params string, salt, key []byte) string {
+	return fmt.Sprintf("%s:$argon2id$v=19$%s$%s$%s", username, params,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte("secret"), salt, 1, 64, 1, 32)
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		line  string
+		valid bool
+	}{
+		{"bcrypt", "alice:" + string(bcryptHash), true},
+		{"argon2id", argon2Line("alice", "m=64,t=1,p=1", salt, key), true},
+		{"comment", "# alice:$apr1$x", true},
+		{"no hash", "alice", false},
+		{"no username", ":" + string(bcryptHash), false},
+		{"apr1", "alice:$apr1$abc$def", false},
+		{"bad bcrypt", "alice:$2y$10$short", false},
+		{"zero time", argon2Line("alice", "m=64,t=0,p=1", salt, key), false},
+		{"zero parallelism", argon2Line("alice", "m=64,t=1,p=0", salt, key), false},
+		{"huge memory", argon2Line("alice", "m=4294967295,t=1,p=1", salt, key), false},
+		{"memory below 8*p", argon2Line("alice", "m=8,t=1,p=4", salt, key), false},
+		{"huge time", argon2Line("alice", "m=64,t=1000000,p=1", salt, key), false},
+		{"short salt", argon2Line("alice", "m=64,t=1,p=1", salt[:4], key), false},
+		{"short key", argon2Line("alice", "m=64,t=1,p=1", salt, key[:8]), false},
+		{"long key", argon2Line("alice", "m=64,t=1,p=1", salt, make([]byte, 128)), false},
+		{"bad version", "alice:$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5", false},
+		{"missing field", "alice:$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ", false},
+	}
+	for _, tt := range tests {
+		_, err := parseHtpasswd([]byte(tt.line))
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+	}
+}
+
+func TestLocalUserVerify(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := argon2.IDKey([]byte("secret"), salt, 1, 64, 1, 32)
+	data := "alice:" + string(bcryptHash) + "\n" + argon2Line("bob", "m=64,t=1,p=1", salt, key)
+	users, err := parseHtpasswd([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		user := users.(map[string]*localUser)[name]
+		if user == nil {
+			t.Fatalf("%s: not found", name)
+		}
+		if !user.verify("secret") {
+			t.Errorf("%s: the password should match", name)
+		}
+		if user.verify("wrong") {
+			t.Errorf("%s: the wrong password should not match", name)
+		}
+	}
+}
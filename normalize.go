@@ -0,0 +1,157 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"regexp"
+	"strings"
+)
+
+const (
+	domainKeep    = "keep"
+	domainStrip   = "strip"
+	domainRequire = "require"
+)
+
+var unicodeForms = map[string]norm.Form{
+	"NFC":  norm.NFC,
+	"NFKC": norm.NFKC,
+}
+
+// usernameRewrite replaces the usernames matching a regular expression.
+type usernameRewrite struct {
+	match   *regexp.Regexp
+	replace string
+}
+
+// usernameNormalization is the pipeline applied to the username before it reaches the LDAP server,
+// the steps run in the order of the fields.
+type usernameNormalization struct {
+	trim          bool
+	unicodeForm   string
+	caseFold      bool
+	domainAliases map[string]string
+	domain        string
+	rewrites      []usernameRewrite
+}
+
+// parseUsernameNormalization parses the usernameNormalization option.
+func parseUsernameNormalization(v interface{}) (*usernameNormalization, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid usernameNormalization: should be an object")
+	}
+	n := &usernameNormalization{}
+	n.trim, _ = m["trim"].(bool)
+	n.caseFold, _ = m["caseFold"].(bool)
+	if form, ok := m["unicode"].(string); ok && form != "" {
+		form = strings.ToUpper(form)
+		if _, ok := unicodeForms[form]; !ok {
+			return nil, fmt.Errorf("invalid usernameNormalization: unknown unicode form %s", form)
+		}
+		n.unicodeForm = form
+	}
+	if aliases, ok := m["domainAliases"]; ok {
+		am, ok := aliases.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid usernameNormalization: domainAliases should be an object")
+		}
+		n.domainAliases = make(map[string]string, len(am))
+		for alias, v := range am {
+			domain, ok := v.(string)
+			if !ok || domain == "" {
+				return nil, fmt.Errorf("invalid usernameNormalization: invalid domain of alias %s", alias)
+			}
+			n.domainAliases[strings.ToLower(alias)] = domain
+		}
+	}
+	if domain, ok := m["domain"].(string); ok && domain != "" {
+		switch domain {
+		case domainKeep, domainStrip, domainRequire:
+		default:
+			return nil, fmt.Errorf("invalid usernameNormalization: unknown domain %s", domain)
+		}
+		n.domain = domain
+	}
+	if rewrites, ok := m["rewrites"]; ok {
+		list, ok := rewrites.([]interface{})
+		if !ok {
+			return nil, errors.New("invalid usernameNormalization: rewrites should be a list")
+		}
+		for _, v := range list {
+			rm, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("invalid usernameNormalization: rewrite should be an object")
+			}
+			match, _ := rm["match"].(string)
+			replace, _ := rm["replace"].(string)
+			re, err := regexp.Compile(match)
+			if match == "" || err != nil {
+				return nil, fmt.Errorf("invalid usernameNormalization: invalid rewrite match %q", match)
+			}
+			n.rewrites = append(n.rewrites, usernameRewrite{match: re, replace: replace})
+		}
+	}
+	return n, nil
+}
+
+// normalize returns the normalized username.
+func (n *usernameNormalization) normalize(username string) (string, error) {
+	if n.trim {
+		username = strings.TrimSpace(username)
+	}
+	if n.unicodeForm != "" {
+		username = unicodeForms[n.unicodeForm].String(username)
+	}
+	if n.caseFold {
+		username = cases.Fold().String(username)
+	}
+
+	local, domain := splitUsername(username)
+	if domain != "" {
+		if canonical, ok := n.domainAliases[strings.ToLower(domain)]; ok {
+			domain = canonical
+		}
+		// keep the form typed by the user
+		if strings.Contains(username, `\`) {
+			username = domain + `\` + local
+		} else {
+			username = local + "@" + domain
+		}
+	}
+	switch n.domain {
+	case domainStrip:
+		username = local
+	case domainRequire:
+		if domain == "" {
+			return "", errors.New("username has no domain")
+		}
+	}
+
+	for _, rewrite := range n.rewrites {
+		username = rewrite.match.ReplaceAllString(username, rewrite.replace)
+	}
+	if username == "" {
+		return "", errors.New("username is empty")
+	}
+	return username, nil
+}
@@ -0,0 +1,481 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The operands of the access rules.
+const (
+	operandAttribute = "attr"
+	operandHeader    = "header"
+	operandGroups    = "groups"
+	operandUser      = "user"
+	operandMethod    = "method"
+	operandPath      = "path"
+	operandSourceIP  = "sourceIp"
+	operandTime      = "time"
+)
+
+// policyContext is what the access rules are evaluated against.
+type policyContext struct {
+	user     *ldapUser
+	username string
+	header   api.RequestHeaderMap
+	sourceIP net.IP
+	now      time.Time
+}
+
+// policyExpr is an expression of an access rule.
+type policyExpr interface {
+	eval(ctx *policyContext) bool
+}
+
+type andExpr struct{ left, right policyExpr }
+
+func (e *andExpr) eval(ctx *policyContext) bool { return e.left.eval(ctx) && e.right.eval(ctx) }
+
+type orExpr struct{ left, right policyExpr }
+
+func (e *orExpr) eval(ctx *policyContext) bool { return e.left.eval(ctx) || e.right.eval(ctx) }
+
+type notExpr struct{ expr policyExpr }
+
+func (e *notExpr) eval(ctx *policyContext) bool { return !e.expr.eval(ctx) }
+
+// compareExpr compares the values of an operand, it holds if any value of a multi-valued operand matches.
+type compareExpr struct {
+	operand string
+	// name of the attribute or the header
+	name   string
+	op     string
+	values []string
+	re     *regexp.Regexp
+	nets   []*net.IPNet
+	// windows of the time operand
+	windows []*timeWindow
+}
+
+// operandValues returns the values of the operand in the context.
+func (e *compareExpr) operandValues(ctx *policyContext) []string {
+	switch e.operand {
+	case operandAttribute:
+		if ctx.user == nil || ctx.user.entry == nil {
+			return nil
+		}
+		return ctx.user.entry.GetEqualFoldAttributeValues(e.name)
+	case operandGroups:
+		if ctx.user == nil {
+			return nil
+		}
+		return ctx.user.groups(ctx.user.backend)
+	case operandUser:
+		return []string{ctx.username}
+	case operandHeader:
+		return ctx.header.Values(e.name)
+	case operandMethod:
+		return []string{ctx.header.Method()}
+	case operandPath:
+		path, _, _ := strings.Cut(ctx.header.Path(), "?")
+		return []string{normalizePath(path)}
+	case operandSourceIP:
+		if ctx.sourceIP == nil {
+			return nil
+		}
+		return []string{ctx.sourceIP.String()}
+	}
+	return nil
+}
+
+// equal returns true if the value of the operand equals the expected value.
+func (e *compareExpr) equal(value, expected string) bool {
+	switch e.operand {
+	case operandGroups:
+		return equalDN(value, expected)
+	case operandPath, operandHeader:
+		return value == expected
+	case operandSourceIP:
+		return net.ParseIP(value).Equal(net.ParseIP(expected))
+	}
+	return strings.EqualFold(value, expected)
+}
+
+// in returns true if the value of the operand is in the set of the expression.
+func (e *compareExpr) in(value string) bool {
+	if e.operand == operandSourceIP {
+		ip := net.ParseIP(value)
+		for _, n := range e.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	for _, expected := range e.values {
+		if e.equal(value, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns true if the value of the operand matches the expression, ignoring its negation.
+func (e *compareExpr) match(value string) bool {
+	switch e.op {
+	case "==", "!=":
+		return e.equal(value, e.values[0])
+	case "=~", "!~":
+		return e.re.MatchString(value)
+	}
+	return e.in(value)
+}
+
+func (e *compareExpr) eval(ctx *policyContext) bool {
+	if e.operand == operandTime {
+		return inTimeWindows(e.windows, ctx.now)
+	}
+	matched := false
+	for _, value := range e.operandValues(ctx) {
+		if e.match(value) {
+			matched = true
+			break
+		}
+	}
+	if e.op == "!=" || e.op == "!~" {
+		return !matched
+	}
+	return matched
+}
+
+// accessRule is a rule which must hold for the authenticated user to access.
+type accessRule struct {
+	source string
+	expr   policyExpr
+}
+
+// policyParser parses the expression of an access rule, with the grammar:
+//
+//	expr    = and { ("||" | "or") and }
+//	and     = unary { ("&&" | "and") unary }
+//	unary   = ("!" | "not") unary | "(" expr ")" | operand op value
+//	operand = "attr." name | "header." name | "groups" | "user" | "method" | "path" | "sourceIp" | "time"
+//	op      = "==" | "!=" | "=~" | "!~" | "in"
+//	value   = string | "[" string { "," string } "]"
+type policyParser struct {
+	tokens []string
+	pos    int
+}
+
+// parseAccessRule parses the expression of an access rule.
+func parseAccessRule(source string) (*accessRule, error) {
+	tokens, err := tokenizePolicy(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &policyParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos])
+	}
+	return &accessRule{source: source, expr: expr}, nil
+}
+
+// tokenizePolicy splits the expression into tokens, the strings keep their quotes.
+func tokenizePolicy(source string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(source) && source[j] != '"'; j++ {
+				if source[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(source) {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, source[i:j+1])
+			i = j + 1
+		case strings.HasPrefix(source[i:], "&&") || strings.HasPrefix(source[i:], "||") ||
+			strings.HasPrefix(source[i:], "==") || strings.HasPrefix(source[i:], "!=") ||
+			strings.HasPrefix(source[i:], "=~") || strings.HasPrefix(source[i:], "!~"):
+			tokens = append(tokens, source[i:i+2])
+			i += 2
+		case strings.ContainsRune("!()[],", rune(c)):
+			tokens = append(tokens, source[i:i+1])
+			i++
+		case isIdentChar(rune(c)):
+			j := i
+			for j < len(source) && isIdentChar(rune(source[j])) {
+				j++
+			}
+			tokens = append(tokens, source[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+func isIdentChar(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_' || r == ';')
+}
+
+func (p *policyParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *policyParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *policyParser) parseOr() (policyExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" || p.peek() == "or" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *policyParser) parseAnd() (policyExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" || p.peek() == "and" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *policyParser) parseUnary() (policyExpr, error) {
+	switch p.peek() {
+	case "!", "not":
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{expr: expr}, nil
+	case "(":
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing )")
+		}
+		return expr, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *policyParser) parseCompare() (policyExpr, error) {
+	e := &compareExpr{}
+	operand := p.next()
+	switch {
+	case strings.HasPrefix(operand, operandAttribute+".") && len(operand) > len(operandAttribute)+1:
+		e.operand, e.name = operandAttribute, operand[len(operandAttribute)+1:]
+	case strings.HasPrefix(operand, operandHeader+".") && len(operand) > len(operandHeader)+1:
+		e.operand, e.name = operandHeader, strings.ToLower(operand[len(operandHeader)+1:])
+	case operand == operandGroups, operand == operandUser, operand == operandMethod,
+		operand == operandPath, operand == operandSourceIP, operand == operandTime:
+		e.operand = operand
+	case operand == "":
+		return nil, errors.New("unexpected end of rule")
+	default:
+		return nil, fmt.Errorf("unknown operand %s", operand)
+	}
+
+	e.op = p.next()
+	if e.operand == operandTime && e.op != "in" {
+		return nil, fmt.Errorf("unknown operator %q for %s, should be in", e.op, operand)
+	}
+	switch e.op {
+	case "==", "!=", "=~", "!~":
+		value, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		e.values = []string{value}
+		if e.op == "=~" || e.op == "!~" {
+			e.re, err = regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression %q: %v", value, err)
+			}
+		}
+	case "in":
+		if p.next() != "[" {
+			return nil, errors.New("in expects a list")
+		}
+		for {
+			value, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			e.values = append(e.values, value)
+			if sep := p.next(); sep == "]" {
+				break
+			} else if sep != "," {
+				return nil, errors.New("missing ] or ,")
+			}
+		}
+		if e.operand == operandSourceIP {
+			values := e.values[:0]
+			for _, value := range e.values {
+				if _, n, err := net.ParseCIDR(value); err == nil {
+					e.nets = append(e.nets, n)
+				} else {
+					values = append(values, value)
+				}
+			}
+			e.values = values
+		}
+		if e.operand == operandTime {
+			for _, value := range e.values {
+				w, err := parseTimeWindowSpec(value)
+				if err != nil {
+					return nil, err
+				}
+				e.windows = append(e.windows, w)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown operator %q for %s", e.op, operand)
+	}
+	return e, nil
+}
+
+func (p *policyParser) parseString() (string, error) {
+	t := p.next()
+	if !strings.HasPrefix(t, `"`) {
+		return "", fmt.Errorf("expected a string instead of %q", t)
+	}
+	s, err := strconv.Unquote(t)
+	if err != nil {
+		return "", fmt.Errorf("invalid string %s", t)
+	}
+	return s, nil
+}
+
+// ruleAttributes returns the attributes of the user entry read by the rules, and whether they read the groups.
+func ruleAttributes(rules []*accessRule) (attributes []string, groups bool) {
+	var walk func(expr policyExpr)
+	walk = func(expr policyExpr) {
+		switch e := expr.(type) {
+		case *andExpr:
+			walk(e.left)
+			walk(e.right)
+		case *orExpr:
+			walk(e.left)
+			walk(e.right)
+		case *notExpr:
+			walk(e.expr)
+		case *compareExpr:
+			switch e.operand {
+			case operandAttribute:
+				if !containsFold(attributes, e.name) {
+					attributes = append(attributes, e.name)
+				}
+			case operandGroups:
+				groups = true
+			}
+		}
+	}
+	for _, rule := range rules {
+		walk(rule.expr)
+	}
+	return attributes, groups
+}
+
+// authorize checks that the authenticated user is allowed to access by the user lists, the source
+// addresses of its groups, the time windows, the access rules and the access control.
+func (f *filter) authorize(header api.RequestHeaderMap, user *ldapUser) *denial {
+	if d := f.checkDenylist(f.username, user.dn, user.name()); d != nil {
+		return d
+	}
+	if d := f.checkAllowlist(user); d != nil {
+		return d
+	}
+	if d := f.checkGroupSources(header, user); d != nil {
+		return d
+	}
+	if d := f.checkTimeWindows(user); d != nil {
+		return d
+	}
+	if d := f.checkAccessRules(header, user); d != nil {
+		return d
+	}
+	return f.checkAccessControl(header, user)
+}
+
+// checkAccessRules checks that the authenticated user satisfies all the access rules.
+func (f *filter) checkAccessRules(header api.RequestHeaderMap, user *ldapUser) *denial {
+	if len(f.config.accessRules) == 0 {
+		return nil
+	}
+	ctx := &policyContext{
+		user:     user,
+		username: f.username,
+		header:   header,
+		sourceIP: sourceIP(header, f.config.xffTrustedHops),
+		now:      time.Now(),
+	}
+	for _, rule := range f.config.accessRules {
+		if !rule.expr.eval(ctx) {
+			f.callbacks.Log(api.Debug, fmt.Sprintf("user %s denied by the access rule: %s", user.name(), rule.source))
+			return f.deny(reasonAccessDenied, "access denied")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// testHeader is a request header map for the tests.
+type testHeader struct {
+	method, path string
+	values       map[string][]string
+}
+
+func newTestHeader(method, path string, kv ...string) *testHeader {
+	h := &testHeader{method: method, path: path, values: make(map[string][]string)}
+	for i := 0; i+1 < len(kv); i += 2 {
+		h.Add(kv[i], kv[i+1])
+	}
+	return h
+}
+
+func (h *testHeader) GetRaw(name string) string { v, _ := h.Get(name); return v }
+func (h *testHeader) Get(key string) (string, bool) {
+	v := h.values[strings.ToLower(key)]
+	if len(v) == 0 {
+		return "", false
+	}
+	return v[0], true
+}
+func (h *testHeader) Values(key string) []string { return h.values[strings.ToLower(key)] }
+func (h *testHeader) Set(key, value string)      { h.values[strings.ToLower(key)] = []string{value} }
+func (h *testHeader) Add(key, value string) {
+	h.values[strings.ToLower(key)] = append(h.values[strings.ToLower(key)], value)
+}
+func (h *testHeader) Del(key string) { delete(h.values, strings.ToLower(key)) }
+func (h *testHeader) Range(f func(key, value string) bool) {
+	for k, vs := range h.values {
+		for _, v := range vs {
+			if !f(k, v) {
+				return
+			}
+		}
+	}
+}
+func (h *testHeader) ByteSize() uint64 { return 0 }
+func (h *testHeader) Protocol() string { return "HTTP/1.1" }
+func (h *testHeader) Scheme() string   { return "https" }
+func (h *testHeader) Method() string   { return h.method }
+func (h *testHeader) Host() string     { return "example.com" }
+func (h *testHeader) Path() string     { return h.path }
+
+func TestParseAccessRule(t *testing.T) {
+	tests := []struct {
+		rule string
+		err  string
+	}{
+		{`user == "alice"`, ""},
+		{`user == "alice" && method == "GET" || path == "/"`, ""},
+		{`not (user == "alice" or user == "bob") and !(method == "GET")`, ""},
+		{`attr.department in ["sales", "it"]`, ""},
+		{`sourceIp in ["10.0.0.0/8", "192.0.2.1"]`, ""},
+		{`header.X-Team =~ "^ops-"`, ""},
+		{`user == "unterminated`, "unterminated string"},
+		{`user == "escaped \" quote`, "unterminated string"},
+		{`groups in []`, "expected a string"},
+		{`groups in ["a" "b"]`, "missing ] or ,"},
+		{`groups in "a"`, "in expects a list"},
+		{`(user == "alice"`, "missing )"},
+		{`user == "alice")`, "unexpected )"},
+		{`user = "alice"`, "unexpected character"},
+		{`user <> "alice"`, "unexpected character"},
+		{`owner == "alice"`, "unknown operand owner"},
+		{`attr. == "x"`, "unknown operand"},
+		{`user ~= "alice"`, "unexpected character"},
+		{`user is "alice"`, "unknown operator"},
+		{`user == alice`, "expected a string"},
+		{`path =~ "("`, "invalid regular expression"},
+		{`user == "alice" &&`, "unexpected end of rule"},
+		{``, "unexpected end of rule"},
+	}
+	for _, tt := range tests {
+		_, err := parseAccessRule(tt.rule)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", tt.rule, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: expected error %q, got %v", tt.rule, tt.err, err)
+		}
+	}
+}
+
+func TestEvalAccessRule(t *testing.T) {
+	user := &ldapUser{
+		dn:      "cn=alice,ou=people,dc=example,dc=com",
+		backend: &config{},
+		entry: ldap.NewEntry("cn=alice,ou=people,dc=example,dc=com", map[string][]string{
+			"department": {"Sales", "IT"},
+			"memberOf":   {"cn=admins,ou=groups,dc=example,dc=com"},
+		}),
+	}
+	ctx := &policyContext{
+		user:     user,
+		username: "alice",
+		header:   newTestHeader("GET", "/api/items?q=1", "x-team", "ops-east", "x-team", "dev"),
+		sourceIP: net.ParseIP("10.1.2.3"),
+	}
+
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		// precedence: && binds tighter than ||
+		{`user == "bob" && method == "GET" || path == "/api/items"`, true},
+		{`user == "bob" && (method == "GET" || path == "/api/items")`, false},
+		{`user == "alice" || user == "bob" && method == "POST"`, true},
+		{`not user == "bob" and method == "GET"`, true},
+		{`!(user == "alice" || user == "bob")`, false},
+		{`!!(user == "alice")`, true},
+		// the comparisons of the user, the method and the attributes ignore the case
+		{`user == "ALICE"`, true},
+		{`method == "get"`, true},
+		// the path excludes the query and is case sensitive
+		{`path == "/api/items"`, true},
+		{`path == "/API/items"`, false},
+		{`path =~ "^/api/"`, true},
+		{`path !~ "^/admin/"`, true},
+		// a multi-valued operand matches if any value matches, and != holds if none does
+		{`attr.department == "it"`, true},
+		{`attr.department != "it"`, false},
+		{`attr.department != "hr"`, true},
+		{`attr.department in ["hr", "sales"]`, true},
+		{`attr.missing != "x"`, true},
+		{`attr.missing == "x"`, false},
+		{`header.X-Team =~ "^ops-"`, true},
+		{`header.x-team !~ "^ops-"`, false},
+		{`header.x-team == "DEV"`, false},
+		{`groups == "CN=Admins,OU=Groups,DC=example,DC=com"`, true},
+		{`groups in ["cn=users,ou=groups,dc=example,dc=com"]`, false},
+		// the source address is matched against the CIDRs and the addresses
+		{`sourceIp in ["10.0.0.0/8"]`, true},
+		{`sourceIp in ["192.168.0.0/16", "10.1.2.3"]`, true},
+		{`sourceIp in ["192.168.0.0/16", "10.1.2.4"]`, false},
+		{`sourceIp == "10.1.2.3"`, true},
+	}
+	for _, tt := range tests {
+		rule, err := parseAccessRule(tt.rule)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.rule, err)
+			continue
+		}
+		if got := rule.expr.eval(ctx); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.rule, got, tt.want)
+		}
+	}
+
+	// the address is unknown
+	rule, err := parseAccessRule(`sourceIp in ["0.0.0.0/0"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.expr.eval(&policyContext{user: user, header: ctx.header}) {
+		t.Errorf("an unknown source address should not match")
+	}
+}
+
+func TestEvalTimeRule(t *testing.T) {
+	rule, err := parseAccessRule(`time in ["mon-fri 08:00-18:00 UTC", "sat 10:00-12:00"] || user == "oncall"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		username string
+		now      time.Time
+		want     bool
+	}{
+		{"alice", time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC), true},
+		{"alice", time.Date(2024, 1, 3, 19, 0, 0, 0, time.UTC), false},
+		{"alice", time.Date(2024, 1, 6, 11, 0, 0, 0, time.UTC), true},
+		{"alice", time.Date(2024, 1, 7, 11, 0, 0, 0, time.UTC), false},
+		{"oncall", time.Date(2024, 1, 7, 11, 0, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		ctx := &policyContext{username: tt.username, header: newTestHeader("GET", "/"), now: tt.now}
+		if got := rule.expr.eval(ctx); got != tt.want {
+			t.Errorf("%s at %s = %v, want %v", tt.username, tt.now, got, tt.want)
+		}
+	}
+
+	for _, rule := range []string{`time == "mon 08:00-18:00"`, `time in ["mon"]`} {
+		if _, err := parseAccessRule(rule); err == nil {
+			t.Errorf("%s: expected an error", rule)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"github.com/go-ldap/ldap/v3"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// adSubCode matches the sub-code in the diagnostic message of Active Directory,
+// e.g. "80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 52e, v4563".
+var adSubCode = regexp.MustCompile(`data ([0-9a-fA-F]+),`)
+
+var adBindErrors = map[string]string{
+	"525": reasonUserNotFound,
+	"52e": reasonInvalidCredentials,
+	"530": reasonLogonHours,
+	"531": reasonLogonWorkstation,
+	"532": reasonPasswordExpired,
+	"533": reasonAccountDisabled,
+	"701": reasonAccountExpired,
+	"773": reasonPasswordMustChange,
+	"775": reasonAccountLocked,
+}
+
+// errPasswordMustChange is returned when the bind succeeds, but the password must be changed before using the account.
+var errPasswordMustChange = errors.New("password must be changed")
+
+// bindFailureReason returns the reason of a failed bind, from the password policy response control
+// or from the sub-code of Active Directory.
+func bindFailureReason(err error, controls []ldap.Control) string {
+	if c, ok := ldap.FindControl(controls, ldap.ControlTypeBeheraPasswordPolicy).(*ldap.ControlBeheraPasswordPolicy); ok {
+		switch c.Error {
+		case 0:
+			return reasonPasswordExpired
+		case 1:
+			return reasonAccountLocked
+		case 2:
+			return reasonPasswordMustChange
+		}
+	}
+	if ldap.FindControl(controls, ldap.ControlTypeVChuPasswordMustChange) != nil {
+		return reasonPasswordMustChange
+	}
+
+	var ldapErr *ldap.Error
+	if !errors.As(err, &ldapErr) || ldapErr.ResultCode != ldap.LDAPResultInvalidCredentials {
+		return reasonLDAPError
+	}
+	if m := adSubCode.FindStringSubmatch(ldapErr.Err.Error()); m != nil {
+		if reason, ok := adBindErrors[strings.ToLower(m[1])]; ok {
+			return reason
+		}
+	}
+	return reasonInvalidCredentials
+}
+
+// bindUser binds as the user with the password policy request control.
+// The reason of a failure and the expiry of the password are recorded on the filter.
+func (f *filter) bindUser(client *ldapConn, userDN, password string) error {
+	res, err := client.SimpleBind(&ldap.SimpleBindRequest{
+		Username: userDN,
+		Password: password,
+		Controls: []ldap.Control{ldap.NewControlBeheraPasswordPolicy()},
+	})
+	var controls []ldap.Control
+	if res != nil {
+		controls = res.Controls
+	}
+	if err != nil {
+		f.reason = bindFailureReason(err, controls)
+		return err
+	}
+
+	// the bind of an account with a reset password succeeds, but the account can't be used
+	if reason := bindFailureReason(nil, controls); reason == reasonPasswordMustChange {
+		f.reason = reason
+		return errPasswordMustChange
+	}
+
+	if c, ok := ldap.FindControl(controls, ldap.ControlTypeBeheraPasswordPolicy).(*ldap.ControlBeheraPasswordPolicy); ok {
+		if c.Expire >= 0 {
+			f.passwordExpiresAt = time.Now().Add(time.Duration(c.Expire) * time.Second)
+		}
+		if c.Grace >= 0 {
+			f.callbacks.Log(api.Warn, fmt.Sprintf("password of %s has expired, %d grace authentications remaining", userDN, c.Grace))
+			f.passwordExpiresAt = time.Now()
+		}
+	}
+	if c, ok := ldap.FindControl(controls, ldap.ControlTypeVChuPasswordWarning).(*ldap.ControlVChuPasswordWarning); ok && c.Expire >= 0 {
+		f.passwordExpiresAt = time.Now().Add(time.Duration(c.Expire) * time.Second)
+	}
+	if !f.passwordExpiresAt.IsZero() {
+		f.callbacks.Log(api.Info, fmt.Sprintf("password of %s expires at %s", userDN, f.passwordExpiresAt.Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// setPasswordExpiryHeader tells the upstream in how many seconds the password of the user expires,
+// if it expires within the passwordExpiryWarning.
+func (f *filter) setPasswordExpiryHeader(header api.RequestHeaderMap) {
+	if f.config.passwordExpiryHeader == "" || f.passwordExpiresAt.IsZero() {
+		return
+	}
+	remaining := time.Until(f.passwordExpiresAt)
+	if f.config.passwordExpiryWarning > 0 && remaining > f.config.passwordExpiryWarning {
+		return
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	header.Set(f.config.passwordExpiryHeader, fmt.Sprintf("%d", int64(remaining.Seconds())))
+}
@@ -0,0 +1,125 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// defaultReferralHopLimit is the default number of referrals followed in a row.
+const defaultReferralHopLimit = 3
+
+// referralURLs returns the URLs of the referral result of an operation.
+func referralURLs(err error) []string {
+	var ldapErr *ldap.Error
+	if !errors.As(err, &ldapErr) || ldapErr.Packet == nil || len(ldapErr.Packet.Children) < 2 {
+		return nil
+	}
+	// LDAPResult ::= SEQUENCE { resultCode, matchedDN, diagnosticMessage, referral [3] Referral OPTIONAL }
+	response := ldapErr.Packet.Children[1]
+	if len(response.Children) < 4 {
+		return nil
+	}
+	var urls []string
+	for _, child := range response.Children[3].Children {
+		if url, ok := child.Value.(string); ok {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// referredConfig returns the config of the server a referral points to, and the base to search there.
+// The server is reached with the same service account and TLS settings, over StartTLS
+// for the ldap:// referrals of a server reached over TLS.
+func referredConfig(conf *config, referral string, base searchBase) (*config, searchBase, error) {
+	u, err := parseLDAPURL(referral)
+	if err != nil {
+		return nil, base, err
+	}
+	if u.scheme == "ldapi" {
+		return nil, base, fmt.Errorf("referral to a unix socket %s is not followed", referral)
+	}
+
+	server := *conf
+	server.host = u.host
+	server.port = u.port
+	server.socketPath = ""
+	// the certificate is verified against the referred host
+	server.serverName = ""
+	server.backends = nil
+	if u.scheme == "ldaps" {
+		server.tls, server.startTLS = true, false
+	} else if conf.tls {
+		// never downgrade, the credentials are sent to the referred server as well
+		server.startTLS = true
+	}
+	if u.scope != "" {
+		server.scope = u.scope
+	}
+	if u.baseDN != "" {
+		base.baseDN = u.baseDN
+	}
+	return &server, base, nil
+}
+
+// followReferrals searches the user on the servers the referrals point to, the unreachable servers are skipped.
+// The number of referrals already followed to reach the current server is given by hops.
+func (f *filter) followReferrals(conf *config, base searchBase, username string, referrals []string, hops int) ([]userEntry, error) {
+	if len(referrals) == 0 {
+		return nil, nil
+	}
+	if hops >= conf.referralHops() {
+		f.callbacks.Log(api.Warn, fmt.Sprintf("referral hop limit (%d) exceeded, skipping referrals: %v", conf.referralHops(), referrals))
+		return nil, nil
+	}
+
+	var entries []userEntry
+	for _, referral := range referrals {
+		server, refBase, err := referredConfig(conf, referral, base)
+		if err != nil {
+			f.callbacks.Log(api.Warn, fmt.Sprintf("invalid referral: %v", err))
+			continue
+		}
+		f.callbacks.Log(api.Debug, fmt.Sprintf("following referral: %s", referral))
+
+		found, err := f.searchReferred(server, refBase, username, hops+1)
+		if err == errAmbiguousUser {
+			return nil, err
+		}
+		if err != nil {
+			f.callbacks.Log(api.Warn, fmt.Sprintf("referral %s error: %v", referral, err))
+			continue
+		}
+		entries = append(entries, found...)
+	}
+	return entries, nil
+}
+
+// searchReferred searches the user on the referred server.
+func (f *filter) searchReferred(server *config, base searchBase, username string, hops int) ([]userEntry, error) {
+	client, err := newLdapClient(server, f.deadline)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return f.searchBase(client, server, base, username, hops)
+}
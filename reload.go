@@ -0,0 +1,91 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadInterval is the minimum interval between two checks of the modification of a reloadable file.
+const reloadInterval = time.Second
+
+// reloadableFile is a file parsed again once it is modified, so that its changes apply without
+// restarting Envoy. A file which can't be read or parsed keeps its previous value.
+type reloadableFile struct {
+	path  string
+	parse func(data []byte) (interface{}, error)
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	modTime   time.Time
+	value     interface{}
+}
+
+// newReloadableFile loads the file, which must be valid at first.
+func newReloadableFile(path string, parse func(data []byte) (interface{}, error)) (*reloadableFile, error) {
+	f := &reloadableFile{path: path, parse: parse}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f.value, err = parse(data)
+	if err != nil {
+		return nil, err
+	}
+	f.modTime, f.checkedAt = info.ModTime(), time.Now()
+	return f, nil
+}
+
+// get returns the parsed content of the file, reloading it if it was modified.
+func (f *reloadableFile) get() interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if time.Since(f.checkedAt) < reloadInterval {
+		return f.value
+	}
+	f.checkedAt = time.Now()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		log.Printf("warning: can't stat %s, keeping its previous content: %v", f.path, err)
+		return f.value
+	}
+	if info.ModTime().Equal(f.modTime) {
+		return f.value
+	}
+	// an invalid file is not read again until it is modified
+	f.modTime = info.ModTime()
+	data, err := os.ReadFile(f.path)
+	if err == nil {
+		var value interface{}
+		value, err = f.parse(data)
+		if err == nil {
+			f.value = value
+			log.Printf("reloaded %s", f.path)
+			return f.value
+		}
+	}
+	log.Printf("warning: can't reload %s, keeping its previous content: %v", f.path, err)
+	return f.value
+}
@@ -0,0 +1,64 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// revokeSessions force-logs out a user: the sessions of the username given in the query are deleted,
+// which takes effect at once in all the Envoy workers. The request must be authenticated with the
+// credentials of one of the revokeAdmins.
+func (f *filter) revokeSessions(header api.RequestHeaderMap) {
+	if header.Method() != http.MethodPost {
+		f.callbacks.SendLocalReply(http.StatusMethodNotAllowed, "method not allowed", map[string]string{"allow": "POST"}, 0, "bad-request")
+		return
+	}
+	if f.sendDenial(f.verify(header)) {
+		return
+	}
+	if !containsFold(f.config.revokeAdmins, f.username) {
+		f.callbacks.Log(api.Info, fmt.Sprintf("user %s is not allowed to revoke sessions", f.username))
+		f.callbacks.SendLocalReply(http.StatusForbidden, "forbidden", map[string]string{}, 0, "revoke-forbidden")
+		return
+	}
+
+	_, query, _ := strings.Cut(header.Path(), "?")
+	values, _ := url.ParseQuery(query)
+	username := values.Get("username")
+	if f.config.normalization != nil && username != "" {
+		normalized, err := f.config.normalization.normalize(username)
+		if err != nil {
+			f.callbacks.SendLocalReply(http.StatusBadRequest, fmt.Sprintf("invalid username: %v", err), map[string]string{}, 0, "bad-request")
+			return
+		}
+		username = normalized
+	}
+	if username == "" {
+		f.callbacks.SendLocalReply(http.StatusBadRequest, "missing username", map[string]string{}, 0, "bad-request")
+		return
+	}
+
+	revoked := sessions.revoke(username)
+	f.callbacks.Log(api.Info, fmt.Sprintf("%d sessions of user %s revoked by %s", revoked, username, f.username))
+	f.callbacks.SendLocalReply(http.StatusOK, fmt.Sprintf("%d sessions revoked\n", revoked), map[string]string{}, 0, "revoke")
+}
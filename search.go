@@ -0,0 +1,164 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"github.com/go-ldap/ldap/v3"
+	"strings"
+	"sync"
+)
+
+// searchBase is a base DN searched for the user in search mode, with the filter used to find the user.
+type searchBase struct {
+	baseDN string
+	filter string
+}
+
+// userEntry is an entry of a user found by the search, with the config of the server holding it,
+// which differs from the searched server if the entry was found by following a referral.
+type userEntry struct {
+	*ldap.Entry
+	server *config
+}
+
+// errAmbiguousUser is returned when the search finds more than one user entry.
+var errAmbiguousUser = errors.New("multiple user entries found")
+
+// search runs a search of the filter, with the Simple Paged Results control if pageSize is set
+// so that the size limit of the directory doesn't truncate the large results.
+// All the searches of the filter go through it.
+func search(client *ldapConn, conf *config, req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if conf.pageSize > 0 {
+		return client.SearchWithPaging(req, conf.pageSize)
+	}
+	return client.Search(req)
+}
+
+// searchBase searches the entries of the user under the base DN, following the referrals if enabled.
+// The number of referrals followed to reach the server is given by hops.
+func (f *filter) searchBase(client *ldapConn, conf *config, base searchBase, username string, hops int) ([]userEntry, error) {
+	req := ldap.NewSearchRequest(
+		base.baseDN,
+		conf.searchScope(),
+		conf.searchDerefAliases(),
+		conf.sizeLimit,
+		conf.timeLimit,
+		false,
+		fmt.Sprintf(base.filter, ldap.EscapeFilter(username)),
+		conf.searchAttributes(), nil)
+
+	sr, err := search(client, conf, req)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		f.callbacks.Log(api.Debug, fmt.Sprintf("search filter exceeded the size limit (%d) in %s", conf.sizeLimit, base.baseDN))
+		return nil, errAmbiguousUser
+	}
+
+	var referrals []string
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultReferral) && conf.followReferrals {
+		// the base DN is held by another server
+		referrals, err = referralURLs(err), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]userEntry, 0, len(sr.Entries))
+	for _, entry := range sr.Entries {
+		entries = append(entries, userEntry{Entry: entry, server: conf})
+	}
+	if conf.followReferrals {
+		referrals = append(referrals, sr.Referrals...)
+		referred, err := f.followReferrals(conf, base, username, referrals, hops)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, referred...)
+	}
+	return entries, nil
+}
+
+// searchUser searches the entry of the user, it fails unless exactly one entry is found.
+// All the bases are searched, in order or at once if parallelSearch is set, and different
+// entries found in different bases are ambiguous.
+func (f *filter) searchUser(client *ldapConn, conf *config, username string) (*userEntry, error) {
+	bases := conf.userSearchBases()
+	results := make([][]userEntry, len(bases))
+
+	if conf.parallelSearch {
+		errs := make([]error, len(bases))
+		var wg sync.WaitGroup
+		for i, base := range bases {
+			wg.Add(1)
+			go func(i int, base searchBase) {
+				defer wg.Done()
+				results[i], errs[i] = f.searchBase(client, conf, base, username, 0)
+			}(i, base)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		for i, base := range bases {
+			entries, err := f.searchBase(client, conf, base, username, 0)
+			if err != nil {
+				return nil, err
+			}
+			results[i] = entries
+		}
+	}
+
+	var found *userEntry
+	for _, entries := range results {
+		for j := range entries {
+			entry := &entries[j]
+			// the bases may overlap, so the same entry can be found more than once
+			if found != nil && !strings.EqualFold(found.DN, entry.DN) {
+				f.callbacks.Log(api.Debug, fmt.Sprintf("search filter return multiple entries: %s and %s", found.DN, entry.DN))
+				return nil, errAmbiguousUser
+			}
+			found = entry
+		}
+	}
+	return found, nil
+}
+
+// findUser searches the entry of the user, the entry is nil if the user is not found or is ambiguous.
+func (f *filter) findUser(client *ldapConn, conf *config, username string) (*userEntry, authResult) {
+	entry, err := f.searchUser(client, conf, username)
+	if err == errAmbiguousUser {
+		f.reason = reasonAmbiguousUser
+		return nil, authDenied
+	}
+	if err != nil {
+		f.callbacks.Log(api.Error, fmt.Sprintf("search error: %v", err))
+		f.reason = reasonLDAPError
+		return nil, authFailed
+	}
+	if entry == nil {
+		f.callbacks.Log(api.Debug, "search filter return empty result")
+		f.reason = reasonUserNotFound
+		return nil, authUnidentified
+	}
+	return entry, authSucceeded
+}
@@ -0,0 +1,112 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// session is the session of a user logged in with the login form.
+type session struct {
+	// normalized username of the user
+	username string
+	// user authenticated by the login, with its entry for the authorization of the requests
+	user *ldapUser
+	// attributes fetched with the entry of the user at the login, nil without entry
+	attributes []string
+	expiresAt  time.Time
+}
+
+// sessionStore holds the sessions. It is shared by all the requests, as the filters of all the
+// Envoy workers run in the same Go runtime.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+var sessions = &sessionStore{sessions: map[string]*session{}}
+
+// randomToken returns a random token, safe to use in cookies and forms.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// create creates a session of the user valid for the ttl, and returns its id.
+func (s *sessionStore) create(username string, user *ldapUser, ttl time.Duration) (string, error) {
+	id, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, sess := range s.sessions {
+		if now.After(sess.expiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+	sess := &session{username: username, user: user, expiresAt: now.Add(ttl)}
+	if user.entry != nil && user.backend != nil {
+		sess.attributes = user.backend.requiredAttributes()
+	}
+	s.sessions[id] = sess
+	return id, nil
+}
+
+// get returns the session with the id, nil if it does not exist or has expired.
+func (s *sessionStore) get(id string) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(sess.expiresAt) {
+		delete(s.sessions, id)
+		return nil
+	}
+	return sess
+}
+
+// delete deletes the session with the id.
+func (s *sessionStore) delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// revoke deletes all the sessions of the user, and returns their number.
+func (s *sessionStore) revoke(username string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revoked := 0
+	for id, sess := range s.sessions {
+		if sess.username == username {
+			delete(s.sessions, id)
+			revoked++
+		}
+	}
+	return revoked
+}
@@ -0,0 +1,151 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"net"
+	"strings"
+)
+
+// sourceIP returns the IP address of the client from the X-Forwarded-For header, nil if unknown.
+// Envoy appends the address of the downstream peer with use_remote_address, which is the last address,
+// and each of the trustedHops proxies in front of Envoy appended the address of its own peer before.
+func sourceIP(header api.RequestHeaderMap, trustedHops int) net.IP {
+	var hops []string
+	for _, value := range header.Values("x-forwarded-for") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	if trustedHops >= len(hops) {
+		return nil
+	}
+	return net.ParseIP(strings.TrimSpace(hops[len(hops)-1-trustedHops]))
+}
+
+// parseCIDRs parses a list of CIDRs, a single address is a CIDR of one address.
+func parseCIDRs(v interface{}) ([]*net.IPNet, error) {
+	list, err := toStringSlice(v)
+	if err != nil {
+		return nil, err
+	}
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %s", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// containsIP returns true if one of the networks contains the address.
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupSources restricts the addresses the members of the groups can access from.
+type groupSources struct {
+	groups []string
+	nets   []*net.IPNet
+}
+
+// parseGroupSources parses the groupSourceAllow option.
+func parseGroupSources(v interface{}) ([]groupSources, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("invalid groupSourceAllow: should be a list")
+	}
+	var all []groupSources
+	for i, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid groupSourceAllow: item %d should be an object", i)
+		}
+		groups, err := toStringSlice(m["groups"])
+		if err != nil || len(groups) == 0 {
+			return nil, fmt.Errorf("invalid groupSourceAllow: item %d requires groups", i)
+		}
+		nets, err := parseCIDRs(m["cidrs"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid groupSourceAllow: item %d: invalid cidrs: %v", i, err)
+		}
+		all = append(all, groupSources{groups: groups, nets: nets})
+	}
+	return all, nil
+}
+
+// checkSource denies the requests from the addresses which are not allowed, before the authentication.
+// The requests whose address is unknown are denied as well.
+func (f *filter) checkSource(header api.RequestHeaderMap) *denial {
+	if len(f.config.sourceAllow) == 0 && len(f.config.sourceDeny) == 0 {
+		return nil
+	}
+	ip := sourceIP(header, f.config.xffTrustedHops)
+	if ip == nil {
+		// e.g. a direct connection which skipped the trusted proxies
+		f.callbacks.Log(api.Info, "source address is unknown")
+		return f.deny(reasonSourceDenied, "access denied")
+	}
+	if containsIP(f.config.sourceDeny, ip) {
+		f.callbacks.Log(api.Info, fmt.Sprintf("source address %s is denied", ip))
+		return f.deny(reasonSourceDenied, "access denied")
+	}
+	if len(f.config.sourceAllow) > 0 && !containsIP(f.config.sourceAllow, ip) {
+		f.callbacks.Log(api.Info, fmt.Sprintf("source address %s is not allowed", ip))
+		return f.deny(reasonSourceDenied, "access denied")
+	}
+	return nil
+}
+
+// checkGroupSources denies the members of the groups of groupSourceAllow accessing from other addresses.
+func (f *filter) checkGroupSources(header api.RequestHeaderMap, user *ldapUser) *denial {
+	if len(f.config.groupSourceAllow) == 0 {
+		return nil
+	}
+	ip := sourceIP(header, f.config.xffTrustedHops)
+	for _, gs := range f.config.groupSourceAllow {
+		if user.memberOf(gs.groups) && !containsIP(gs.nets, ip) {
+			f.callbacks.Log(api.Info, fmt.Sprintf("user %s is not allowed to access from %v", user.name(), ip))
+			return f.deny(reasonSourceDenied, "access denied")
+		}
+	}
+	return nil
+}
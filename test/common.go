@@ -21,22 +21,30 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 )
 
 func startEnvoyBind(host string, port int, baseDn, attribute string) {
-	startEnvoy(host, port, baseDn, attribute, "", "", "", false)
+	startEnvoy(host, port, baseDn, attribute, "", "", "", false, nil)
+}
+
+func startEnvoyBindTemplate(host string, port int, baseDn, attribute, bindDnTemplate string) {
+	startEnvoy(host, port, baseDn, attribute, "", "", "", false, map[string]string{
+		"bindDnTemplate": bindDnTemplate,
+	})
 }
 
 func startEnvoySearch(host string, port int, baseDn, attribute, bindDn, bindPassword, filter string) {
-	startEnvoy(host, port, baseDn, attribute, bindDn, bindPassword, filter, false)
+	startEnvoy(host, port, baseDn, attribute, bindDn, bindPassword, filter, false, nil)
 }
 
 func startEnvoyTLS(host string, port int, baseDn, attribute string) {
-	startEnvoy(host, port, baseDn, attribute, "", "", "", true)
+	startEnvoy(host, port, baseDn, attribute, "", "", "", true, nil)
 }
 
-func startEnvoy(host string, port int, baseDn, attribute, bindDn, bindPassword, filter string, tls bool) {
-	generateEnvoyConfig(host, port, baseDn, attribute, bindDn, bindPassword, filter, tls)
+// startEnvoy starts envoy with the given filter config, the extra options are added to the filter config as is.
+func startEnvoy(host string, port int, baseDn, attribute, bindDn, bindPassword, filter string, tls bool, extra map[string]string) {
+	generateEnvoyConfig(host, port, baseDn, attribute, bindDn, bindPassword, filter, tls, extra)
 	var err error
 	if tls {
 		err = exec.Command("bash", "-c", `sed -i "s/host: localhost/host: $(ifconfig eth0 | awk '/inet / {print $2}')/" envoy.yaml`).Run()
@@ -62,7 +70,12 @@ func startEnvoy(host string, port int, baseDn, attribute, bindDn, bindPassword,
 	}
 }
 
-func generateEnvoyConfig(host string, port int, baseDn, attribute, bindDn, bindPassword, filter string, tls bool) {
+func generateEnvoyConfig(host string, port int, baseDn, attribute, bindDn, bindPassword, filter string, tls bool, extra map[string]string) {
+	var extraConfig strings.Builder
+	for key, value := range extra {
+		extraConfig.WriteString(fmt.Sprintf("                          %s: %q\n", key, value))
+	}
+
 	config := fmt.Sprintf(`
 static_resources:
 
@@ -108,7 +121,7 @@ static_resources:
                           startTLS: # false
                           insecureSkipVerify: # false
                           rootCA: # ""
-
+%s
                   - name: envoy.filters.http.router
                     typed_config:
                       "@type": type.googleapis.com/envoy.extensions.filters.http.router.v3.Router
@@ -143,7 +156,7 @@ static_resources:
         typed_config:
           "@type": type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext
           sni: mosn.io
-`, host, port, baseDn, attribute, bindDn, bindPassword, filter, tls)
+`, host, port, baseDn, attribute, bindDn, bindPassword, filter, tls, extraConfig.String())
 
 	// Write the configuration to the specified file
 	err := os.WriteFile("envoy.yaml", []byte(config), 0644)
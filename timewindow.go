@@ -0,0 +1,239 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"github.com/go-ldap/ldap/v3"
+	"strings"
+	"time"
+	// the time zones are embedded, as the Envoy image may not have them
+	_ "time/tzdata"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// timeWindow is a window of the week, in minutes since midnight in its time zone.
+// A window whose end is before its start ends on the next day.
+type timeWindow struct {
+	days     [7]bool
+	start    int
+	end      int
+	location *time.Location
+}
+
+// parseTimeWindows parses the timeWindows option.
+func parseTimeWindows(v interface{}) ([]*timeWindow, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("invalid timeWindows: should be a list")
+	}
+	windows := make([]*timeWindow, 0, len(list))
+	for i, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid timeWindows: window %d should be an object", i)
+		}
+		w, err := parseTimeWindow(m)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeWindows: window %d: %v", i, err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseTimeWindow(m map[string]interface{}) (*timeWindow, error) {
+	w := &timeWindow{start: 0, end: 24 * 60, location: time.UTC}
+	if v, ok := m["days"]; ok {
+		days, err := toStringSlice(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid days: %v", err)
+		}
+		for _, day := range days {
+			if err := w.addDays(day); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	var err error
+	if start, ok := m["start"].(string); ok {
+		if w.start, err = parseTimeOfDay(start); err != nil {
+			return nil, err
+		}
+	}
+	if end, ok := m["end"].(string); ok {
+		if w.end, err = parseTimeOfDay(end); err != nil {
+			return nil, err
+		}
+	}
+	if w.start == w.end {
+		return nil, errors.New("empty window")
+	}
+	if tz, ok := m["timeZone"].(string); ok && tz != "" {
+		if w.location, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("invalid timeZone: %v", err)
+		}
+	}
+	return w, nil
+}
+
+// parseTimeWindowSpec parses a window written as a string, e.g. "mon-fri 08:00-18:00 Europe/Paris",
+// with optional days separated by commas, a time range and an optional time zone.
+func parseTimeWindowSpec(spec string) (*timeWindow, error) {
+	m := make(map[string]interface{})
+	for _, field := range strings.Fields(spec) {
+		switch {
+		case strings.Contains(field, ":"):
+			start, end, ok := strings.Cut(field, "-")
+			if !ok {
+				return nil, fmt.Errorf("invalid time range %q, should be HH:MM-HH:MM", field)
+			}
+			m["start"], m["end"] = start, end
+		case isDays(field):
+			var days []interface{}
+			for _, day := range strings.Split(field, ",") {
+				days = append(days, day)
+			}
+			m["days"] = days
+		default:
+			m["timeZone"] = field
+		}
+	}
+	if _, ok := m["start"]; !ok {
+		return nil, fmt.Errorf("invalid time window %q: no time range", spec)
+	}
+	w, err := parseTimeWindow(m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time window %q: %v", spec, err)
+	}
+	return w, nil
+}
+
+// isDays returns true if the field of a window is a list of days, such as mon-fri,sun.
+func isDays(field string) bool {
+	for _, part := range strings.Split(strings.ToLower(field), ",") {
+		for _, day := range strings.Split(part, "-") {
+			if _, ok := weekdays[day]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// addDays adds a day, or a range of days such as mon-fri, to the window.
+func (w *timeWindow) addDays(s string) error {
+	first, last, isRange := strings.Cut(strings.ToLower(s), "-")
+	from, ok := weekdays[first]
+	if !ok {
+		return fmt.Errorf("invalid day %s", s)
+	}
+	to := from
+	if isRange {
+		if to, ok = weekdays[last]; !ok {
+			return fmt.Errorf("invalid day %s", s)
+		}
+	}
+	for d := from; ; d = (d + 1) % 7 {
+		w.days[d] = true
+		if d == to {
+			return nil
+		}
+	}
+}
+
+// parseTimeOfDay parses a time of the day HH:MM into minutes since midnight, up to 24:00.
+func parseTimeOfDay(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q, should be HH:MM", s)
+	}
+	return h*60 + m, nil
+}
+
+// contains returns true if the time is within the window.
+func (w *timeWindow) contains(t time.Time) bool {
+	t = t.In(w.location)
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	// the window started on the previous day
+	previous := (day + 6) % 7
+	return (w.days[day] && minute >= w.start) || (w.days[previous] && minute < w.end)
+}
+
+// logonHoursAllow returns true if the Active Directory logonHours attribute allows to log on at the time.
+// The attribute has a bit per hour of the week in UTC, from Sunday midnight, the lowest bit first.
+func logonHoursAllow(entry *ldap.Entry, t time.Time) bool {
+	hours := entry.GetEqualFoldRawAttributeValue("logonHours")
+	if len(hours) == 0 {
+		// no restriction
+		return true
+	}
+	if len(hours) != 21 {
+		return false
+	}
+	t = t.UTC()
+	hour := int(t.Weekday())*24 + t.Hour()
+	return hours[hour/8]&(1<<(hour%8)) != 0
+}
+
+// inTimeWindows returns true if the time is within one of the windows.
+func inTimeWindows(windows []*timeWindow, t time.Time) bool {
+	for _, w := range windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTimeWindows denies the access outside the timeWindows, and outside the logonHours of the user if enabled.
+func (f *filter) checkTimeWindows(user *ldapUser) *denial {
+	now := time.Now()
+	if f.config.logonHours && user.entry != nil && !logonHoursAllow(user.entry, now) {
+		f.callbacks.Log(api.Info, fmt.Sprintf("user %s is outside its logon hours", user.name()))
+		return f.deny(reasonLogonHours, "access denied outside the logon hours")
+	}
+	if len(f.config.timeWindows) == 0 {
+		return nil
+	}
+	if len(f.config.timeWindowGroups) > 0 && !user.memberOf(f.config.timeWindowGroups) {
+		return nil
+	}
+	if inTimeWindows(f.config.timeWindows, now) {
+		return nil
+	}
+	f.callbacks.Log(api.Info, fmt.Sprintf("user %s is outside the time windows", user.name()))
+	return f.deny(reasonTimeWindow, "access denied outside the allowed hours")
+}
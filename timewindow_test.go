@@ -0,0 +1,157 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// 2024-01-01 is a Monday.
+func at(day int, hour, minute int) time.Time {
+	return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	tests := []struct {
+		name   string
+		window map[string]interface{}
+		time   time.Time
+		want   bool
+	}{
+		{"office hours", map[string]interface{}{"days": []interface{}{"mon-fri"}, "start": "08:00", "end": "18:00"}, at(3, 8, 0), true},
+		{"office hours end", map[string]interface{}{"days": []interface{}{"mon-fri"}, "start": "08:00", "end": "18:00"}, at(3, 18, 0), false},
+		{"office hours weekend", map[string]interface{}{"days": []interface{}{"mon-fri"}, "start": "08:00", "end": "18:00"}, at(6, 12, 0), false},
+		{"overnight evening", map[string]interface{}{"days": []interface{}{"mon"}, "start": "22:00", "end": "06:00"}, at(1, 23, 30), true},
+		{"overnight next morning", map[string]interface{}{"days": []interface{}{"mon"}, "start": "22:00", "end": "06:00"}, at(2, 5, 59), true},
+		{"overnight next morning end", map[string]interface{}{"days": []interface{}{"mon"}, "start": "22:00", "end": "06:00"}, at(2, 6, 0), false},
+		{"overnight morning of the day", map[string]interface{}{"days": []interface{}{"mon"}, "start": "22:00", "end": "06:00"}, at(1, 5, 0), false},
+		{"overnight sunday to monday", map[string]interface{}{"days": []interface{}{"sun"}, "start": "22:00", "end": "06:00"}, at(8, 1, 0), true},
+		{"wrapping days friday", map[string]interface{}{"days": []interface{}{"fri-mon"}}, at(5, 12, 0), true},
+		{"wrapping days sunday", map[string]interface{}{"days": []interface{}{"fri-mon"}}, at(7, 12, 0), true},
+		{"wrapping days monday", map[string]interface{}{"days": []interface{}{"fri-mon"}}, at(8, 12, 0), true},
+		{"wrapping days tuesday", map[string]interface{}{"days": []interface{}{"fri-mon"}}, at(2, 12, 0), false},
+		{"end of day", map[string]interface{}{"start": "20:00", "end": "24:00"}, at(3, 23, 59), true},
+		{"end of day next day", map[string]interface{}{"days": []interface{}{"wed"}, "start": "20:00", "end": "24:00"}, at(4, 0, 0), false},
+		{"whole day", map[string]interface{}{"days": []interface{}{"sat", "sun"}}, at(6, 0, 0), true},
+		{"time zone", map[string]interface{}{"start": "08:00", "end": "18:00", "timeZone": "Europe/Paris"}, at(3, 7, 30), true},
+		{"time zone before", map[string]interface{}{"start": "08:00", "end": "18:00", "timeZone": "Europe/Paris"}, at(3, 6, 30), false},
+	}
+	for _, tt := range tests {
+		w, err := parseTimeWindow(tt.window)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if got := w.contains(tt.time); got != tt.want {
+			t.Errorf("%s: contains(%s) = %v, want %v", tt.name, tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestParseTimeWindow(t *testing.T) {
+	for _, m := range []map[string]interface{}{
+		{"days": []interface{}{"monday"}},
+		{"days": []interface{}{"mon-xyz"}},
+		{"start": "25:00"},
+		{"end": "24:01"},
+		{"start": "08:60"},
+		{"start": "8h"},
+		{"start": "10:00", "end": "10:00"},
+		{"timeZone": "Mars/Olympus"},
+	} {
+		if _, err := parseTimeWindow(m); err == nil {
+			t.Errorf("%v: expected an error", m)
+		}
+	}
+}
+
+func TestParseTimeWindowSpec(t *testing.T) {
+	w, err := parseTimeWindowSpec("sat,sun 22:00-06:00 America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sunday 03:00 in New York
+	if !w.contains(time.Date(2024, 1, 7, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("the window should contain Sunday 03:00 in New York")
+	}
+	// Monday 03:00 in New York, after the window started on Sunday
+	if !w.contains(time.Date(2024, 1, 8, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("the window should contain Monday 03:00 in New York")
+	}
+	// Tuesday 03:00 in New York
+	if w.contains(time.Date(2024, 1, 9, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("the window should not contain Tuesday 03:00 in New York")
+	}
+
+	for _, spec := range []string{"", "mon-fri", "mon-fri 08:00", "08:00-18:00 Mars/Olympus", "08:00-25:00"} {
+		if _, err := parseTimeWindowSpec(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestLogonHoursAllow(t *testing.T) {
+	entry := func(hours []byte) *ldap.Entry {
+		e := ldap.NewEntry("cn=alice,dc=example,dc=com", nil)
+		if hours != nil {
+			e.Attributes = append(e.Attributes, &ldap.EntryAttribute{Name: "logonHours", Values: []string{string(hours)}, ByteValues: [][]byte{hours}})
+		}
+		return e
+	}
+
+	if !logonHoursAllow(entry(nil), at(1, 12, 0)) {
+		t.Errorf("a user without logonHours should not be restricted")
+	}
+	if logonHoursAllow(entry(make([]byte, 20)), at(1, 12, 0)) {
+		t.Errorf("an invalid logonHours should deny")
+	}
+
+	// Sunday 00:00-01:00 UTC is the lowest bit of the first byte
+	hours := make([]byte, 21)
+	hours[0] = 0x01
+	if !logonHoursAllow(entry(hours), at(7, 0, 30)) {
+		t.Errorf("Sunday 00:30 should be allowed")
+	}
+	if logonHoursAllow(entry(hours), at(7, 1, 0)) {
+		t.Errorf("Sunday 01:00 should be denied")
+	}
+
+	// Monday 09:00-10:00 UTC is hour 33, the bit 1 of the byte 4
+	hours = make([]byte, 21)
+	hours[4] = 0x02
+	if !logonHoursAllow(entry(hours), at(1, 9, 15)) {
+		t.Errorf("Monday 09:15 should be allowed")
+	}
+	if logonHoursAllow(entry(hours), at(1, 10, 15)) {
+		t.Errorf("Monday 10:15 should be denied")
+	}
+	// the hours are in UTC
+	if !logonHoursAllow(entry(hours), time.Date(2024, 1, 1, 10, 15, 0, 0, time.FixedZone("CET", 3600))) {
+		t.Errorf("Monday 10:15 CET should be allowed")
+	}
+
+	// Saturday 23:00-24:00 UTC is the highest bit of the last byte
+	hours = make([]byte, 21)
+	hours[20] = 0x80
+	if !logonHoursAllow(entry(hours), at(6, 23, 59)) {
+		t.Errorf("Saturday 23:59 should be allowed")
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion parses a TLS version such as "1.2".
+func parseTLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[strings.TrimPrefix(version, "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version: %s", version)
+	}
+	return v, nil
+}
+
+// parseCipherSuites parses a list of cipher suite names, e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".
+func parseCipherSuites(names []string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		suites[s.Name] = s.ID
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		suites[s.Name] = s.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite: %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parsePinnedPublicKey parses a base64-encoded SHA-256 hash of a SubjectPublicKeyInfo,
+// optionally prefixed with "sha256//" like curl's --pinnedpubkey.
+func parsePinnedPublicKey(pin string) ([]byte, error) {
+	hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256//"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pinned public key %s: %v", pin, err)
+	}
+	if len(hash) != sha256.Size {
+		return nil, fmt.Errorf("invalid pinned public key %s: not a SHA-256 hash", pin)
+	}
+	return hash, nil
+}
+
+// verifyPinnedPublicKey checks that the certificate of the LDAP server matches one of the pinned public keys.
+func verifyPinnedPublicKey(certs []*x509.Certificate, pins [][]byte) error {
+	if len(certs) == 0 {
+		return errors.New("no certificate presented by the LDAP server")
+	}
+	// only the leaf is checked, as the chain is not verified when pinning
+	hash := sha256.Sum256(certs[0].RawSubjectPublicKeyInfo)
+	for _, pin := range pins {
+		if bytes.Equal(hash[:], pin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("public key of the LDAP server certificate is not pinned: sha256//%s",
+		base64.StdEncoding.EncodeToString(hash[:]))
+}
+
+// parseCertificates parses all the PEM-encoded certificates, it fails if there is none.
+func parseCertificates(data string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM-encoded certificate found")
+	}
+	return certs, nil
+}
+
+// reportCertificateExpiry logs when the certificates expire, so that they can be renewed in time.
+func reportCertificateExpiry(name string, certs []*x509.Certificate) {
+	now := time.Now()
+	for _, cert := range certs {
+		if now.After(cert.NotAfter) {
+			log.Printf("warning: %s certificate %q has expired at %s", name, cert.Subject, cert.NotAfter.Format(time.RFC3339))
+			continue
+		}
+		log.Printf("%s certificate %q expires at %s", name, cert.Subject, cert.NotAfter.Format(time.RFC3339))
+	}
+}
+
+// newTLSConfig creates the TLS configuration used to connect to the LDAP server.
+func newTLSConfig(conf *config) *tls.Config {
+	var rootCA *x509.CertPool
+
+	if len(conf.rootCACerts) > 0 {
+		if conf.useSystemCA {
+			// the system pool is validated in parser.Parse
+			rootCA, _ = x509.SystemCertPool()
+		}
+		if rootCA == nil {
+			rootCA = x509.NewCertPool()
+		}
+		for _, cert := range conf.rootCACerts {
+			rootCA.AddCert(cert)
+		}
+	}
+
+	serverName := conf.serverName
+	if serverName == "" {
+		serverName = conf.host
+	}
+
+	tlsCfg := &tls.Config{
+		InsecureSkipVerify: conf.insecureSkipVerify,
+		ServerName:         serverName,
+		RootCAs:            rootCA,
+		MinVersion:         conf.minTLSVersion,
+		MaxVersion:         conf.maxTLSVersion,
+		CipherSuites:       conf.cipherSuites,
+	}
+	if conf.clientCertificate != nil {
+		tlsCfg.Certificates = []tls.Certificate{*conf.clientCertificate}
+	}
+	if len(conf.pinnedPublicKeys) > 0 {
+		// The pinned public keys replace the verification of the certificate chain,
+		// so that self-signed certificates can be trusted without skipping the verification.
+		pins := conf.pinnedPublicKeys
+		tlsCfg.InsecureSkipVerify = true
+		tlsCfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPinnedPublicKey(cs.PeerCertificates, pins)
+		}
+	}
+	return tlsCfg
+}
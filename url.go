@@ -0,0 +1,122 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ldapURL is an LDAP URL as described in RFC 4516, e.g. ldap://host:389/dc=example,dc=com?cn?sub?(uid=%25s).
+// The ldapi scheme is supported as well, its host is the percent-encoded path of a Unix domain socket.
+type ldapURL struct {
+	scheme     string
+	host       string
+	port       uint64
+	socketPath string
+	baseDN     string
+	attributes []string
+	scope      string
+	filter     string
+}
+
+// parseLDAPURL parses an LDAP URL.
+func parseLDAPURL(raw string) (*ldapURL, error) {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid LDAP URL %s: missing scheme", raw)
+	}
+	u := &ldapURL{scheme: strings.ToLower(scheme)}
+
+	hostport, rest, _ := strings.Cut(rest, "/")
+	switch u.scheme {
+	case "ldapi":
+		socketPath, err := url.PathUnescape(hostport)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LDAP URL %s: %v", raw, err)
+		}
+		if socketPath == "" {
+			socketPath = "/var/run/slapd/ldapi"
+		}
+		u.socketPath = socketPath
+	case "ldap", "ldaps":
+		hu, err := url.Parse(u.scheme + "://" + hostport)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LDAP URL %s: %v", raw, err)
+		}
+		u.host = hu.Hostname()
+		if u.host == "" {
+			u.host = "localhost"
+		}
+		u.port = 389
+		if u.scheme == "ldaps" {
+			u.port = 636
+		}
+		if hu.Port() != "" {
+			u.port, err = strconv.ParseUint(hu.Port(), 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid LDAP URL %s: invalid port %s", raw, hu.Port())
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid LDAP URL %s: unsupported scheme %s", raw, scheme)
+	}
+
+	dn, query, _ := strings.Cut(rest, "?")
+	var err error
+	if u.baseDN, err = url.PathUnescape(dn); err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL %s: %v", raw, err)
+	}
+	if query == "" {
+		return u, nil
+	}
+
+	// attributes?scope?filter?extensions
+	parts := strings.Split(query, "?")
+	if len(parts) > 4 {
+		return nil, fmt.Errorf("invalid LDAP URL %s: too many query components", raw)
+	}
+	for i, part := range parts {
+		if parts[i], err = url.PathUnescape(part); err != nil {
+			return nil, fmt.Errorf("invalid LDAP URL %s: %v", raw, err)
+		}
+	}
+	if parts[0] != "" {
+		u.attributes = strings.Split(parts[0], ",")
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		u.scope = strings.ToLower(parts[1])
+		if _, ok := searchScopes[u.scope]; !ok {
+			return nil, fmt.Errorf("invalid LDAP URL %s: unknown scope %s", raw, parts[1])
+		}
+	}
+	if len(parts) > 2 {
+		u.filter = parts[2]
+	}
+	if len(parts) > 3 && parts[3] != "" {
+		for _, ext := range strings.Split(parts[3], ",") {
+			// no extension is supported, the critical ones must be rejected
+			if strings.HasPrefix(ext, "!") {
+				return nil, fmt.Errorf("invalid LDAP URL %s: unsupported critical extension %s", raw, ext[1:])
+			}
+		}
+	}
+	return u, nil
+}
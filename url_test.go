@@ -0,0 +1,86 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLDAPURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want ldapURL
+	}{
+		{"ldap://ldap.example.com", ldapURL{scheme: "ldap", host: "ldap.example.com", port: 389}},
+		{"LDAPS://ldap.example.com/", ldapURL{scheme: "ldaps", host: "ldap.example.com", port: 636}},
+		{"ldap://", ldapURL{scheme: "ldap", host: "localhost", port: 389}},
+		{"ldap://ldap.example.com:3389/dc=example,dc=com", ldapURL{scheme: "ldap", host: "ldap.example.com", port: 3389, baseDN: "dc=example,dc=com"}},
+		{"ldap://[2001:db8::1]:1389/dc=example,dc=com", ldapURL{scheme: "ldap", host: "2001:db8::1", port: 1389, baseDN: "dc=example,dc=com"}},
+		{"ldaps://[::1]", ldapURL{scheme: "ldaps", host: "::1", port: 636}},
+		{"ldap://host/ou=R%26D,dc=example,dc=com", ldapURL{scheme: "ldap", host: "host", port: 389, baseDN: "ou=R&D,dc=example,dc=com"}},
+		{"ldap://host/dc=example,dc=com?cn,mail?one?(uid=%25s)", ldapURL{
+			scheme: "ldap", host: "host", port: 389, baseDN: "dc=example,dc=com",
+			attributes: []string{"cn", "mail"}, scope: "one", filter: "(uid=%s)",
+		}},
+		{"ldap://host/dc=example,dc=com??SUB?(%26(objectClass=person)(cn=%25s%3F))", ldapURL{
+			scheme: "ldap", host: "host", port: 389, baseDN: "dc=example,dc=com",
+			scope: "sub", filter: "(&(objectClass=person)(cn=%s?))",
+		}},
+		{"ldap://host/dc=example,dc=com???(uid=%25s)?x-ext,e-bindname=cn%3Dadmin", ldapURL{
+			scheme: "ldap", host: "host", port: 389, baseDN: "dc=example,dc=com", filter: "(uid=%s)",
+		}},
+		{"ldapi://%2Fvar%2Frun%2Fldapi/dc=example,dc=com", ldapURL{scheme: "ldapi", socketPath: "/var/run/ldapi", baseDN: "dc=example,dc=com"}},
+		{"ldapi:///dc=example,dc=com", ldapURL{scheme: "ldapi", socketPath: "/var/run/slapd/ldapi", baseDN: "dc=example,dc=com"}},
+	}
+	for _, tt := range tests {
+		got, err := parseLDAPURL(tt.raw)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.raw, *got, tt.want)
+		}
+	}
+}
+
+func TestParseLDAPURLErrors(t *testing.T) {
+	tests := []struct {
+		raw string
+		err string
+	}{
+		{"ldap.example.com", "missing scheme"},
+		{"http://ldap.example.com", "unsupported scheme"},
+		{"ldap://host:99999", "invalid port"},
+		{"ldap://host:port", "invalid"},
+		{"ldap://host/dc=example%zz", "invalid"},
+		{"ldapi://%zz/", "invalid"},
+		{"ldap://host/dc=example,dc=com??subtree", "unknown scope"},
+		{"ldap://host/dc=example,dc=com?cn?sub?(uid=%25s)?!e-bindname=cn%3Dadmin", "unsupported critical extension e-bindname"},
+		{"ldap://host/dc=example,dc=com?cn?sub?(uid=%25s)?x-ext?more", "too many query components"},
+		{"ldap://host/dc=example,dc=com???(uid=%zz)", "invalid"},
+	}
+	for _, tt := range tests {
+		_, err := parseLDAPURL(tt.raw)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: expected error %q, got %v", tt.raw, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"strings"
+)
+
+// userList is a list of patterns of usernames and DNs, from the config and optionally from a file.
+type userList struct {
+	patterns []string
+	file     *reloadableFile
+}
+
+// parseUserList parses the list of the key and the file of the fileKey, nil if neither is set.
+func parseUserList(m map[string]interface{}, key, fileKey string) (*userList, error) {
+	l := &userList{}
+	if v, ok := m[key]; ok {
+		patterns, err := toStringSlice(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", key, err)
+		}
+		l.patterns = patterns
+	}
+	if path, ok := m[fileKey].(string); ok && path != "" {
+		file, err := newReloadableFile(path, parseUserListFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", fileKey, err)
+		}
+		l.file = file
+	}
+	if len(l.patterns) == 0 && l.file == nil {
+		return nil, nil
+	}
+	return l, nil
+}
+
+// parseUserListFile parses a file with a pattern per line, the empty lines and the lines starting with # are ignored.
+func parseUserListFile(data []byte) (interface{}, error) {
+	var patterns []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// matches returns true if one of the names matches a pattern of the list.
+func (l *userList) matches(names ...string) bool {
+	patterns := l.patterns
+	if l.file != nil {
+		patterns = append(patterns[:len(patterns):len(patterns)], l.file.get().([]string)...)
+	}
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if name != "" && matchGlob(pattern, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchGlob matches the name against the pattern ignoring the case, where * matches any
+// sequence of characters and ? matches a single character.
+func matchGlob(pattern, name string) bool {
+	p, n := []rune(strings.ToLower(pattern)), []rune(strings.ToLower(name))
+	// the position of the last * of the pattern and the position of the name it matched up to, to backtrack
+	i, j, star, mark := 0, 0, -1, 0
+	for j < len(n) {
+		switch {
+		case i < len(p) && p[i] == '*':
+			star, mark = i, j
+			i++
+		case i < len(p) && (p[i] == '?' || p[i] == n[j]):
+			i++
+			j++
+		case star >= 0:
+			mark++
+			i, j = star+1, mark
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
+
+// checkDenylist denies the users of the denylist before querying the LDAP server.
+func (f *filter) checkDenylist(names ...string) *denial {
+	if f.config.userDenylist == nil || !f.config.userDenylist.matches(names...) {
+		return nil
+	}
+	f.callbacks.Log(api.Info, fmt.Sprintf("user %q is in the denylist", names[0]))
+	return f.deny(reasonUserDenied, "access denied")
+}
+
+// checkAllowlist denies the authenticated users which are not in the allowlist.
+func (f *filter) checkAllowlist(user *ldapUser) *denial {
+	if f.config.userAllowlist == nil {
+		return nil
+	}
+	if f.config.userAllowlist.matches(f.username, f.originalUsername, user.dn, user.name()) {
+		return nil
+	}
+	f.callbacks.Log(api.Debug, fmt.Sprintf("user %s is not in the allowlist", user.name()))
+	return f.deny(reasonAccessDenied, "access denied")
+}
@@ -0,0 +1,87 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"", "", true},
+		{"", "alice", false},
+		{"*", "", true},
+		{"*", "alice", true},
+		{"**", "alice", true},
+		{"alice", "alice", true},
+		{"alice", "alicia", false},
+		{"alice", "alice2", false},
+		{"alice", "xalice", false},
+		{"svc-*", "svc-backup", true},
+		{"svc-*", "svc-", true},
+		{"svc-*", "svc", false},
+		{"svc-*", "my-svc-backup", false},
+		{"*-admin", "alice-admin", true},
+		{"*-admin", "alice-admin2", false},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyycd", false},
+		{"a*bc", "abcbc", true},
+		{"a*", "a*b", true},
+		{"*b", "a*b", true},
+		{"user?", "user1", true},
+		{"user?", "user", false},
+		{"user?", "user12", false},
+		{"??", "éü", true},
+		{"Alice", "ALICE", true},
+		{"ALICE*", "alice.smith", true},
+		{"ÉLODIE", "élodie", true},
+		{"cn=alice,ou=people,dc=example,dc=com", "CN=Alice,OU=People,DC=example,DC=com", true},
+		{"cn=*,ou=terminated,dc=example,dc=com", "cn=bob,ou=terminated,dc=example,dc=com", true},
+		{"cn=*,ou=terminated,dc=example,dc=com", "cn=bob,ou=people,dc=example,dc=com", false},
+		{"*,ou=terminated,*", "uid=bob,ou=terminated,dc=example,dc=com", true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUserListMatches(t *testing.T) {
+	l, err := parseUserList(map[string]interface{}{"userDenylist": []interface{}{"mallory", "svc-*"}}, "userDenylist", "userDenylistFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.matches("", "Mallory") {
+		t.Errorf("the second name should match")
+	}
+	if !l.matches("svc-backup") {
+		t.Errorf("the glob should match")
+	}
+	if l.matches("", "alice") {
+		t.Errorf("alice should not match")
+	}
+
+	l, err = parseUserList(map[string]interface{}{}, "userDenylist", "userDenylistFile")
+	if err != nil || l != nil {
+		t.Errorf("an empty list should be nil, got %v, %v", l, err)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/envoyproxy/envoy/contrib/golang/filters/http/source/go/pkg/api"
+	"strings"
+)
+
+// verifyIdentity asks the server the authorization identity of the bound user with the "Who am I?"
+// operation (RFC 4532), and makes it the identity of the user. boundDN is the DN the user bound as,
+// empty if the user bound with another form of name.
+func (f *filter) verifyIdentity(client *ldapConn, conf *config, user *ldapUser, boundDN string) authResult {
+	res, err := client.WhoAmI(nil)
+	if err != nil {
+		f.callbacks.Log(api.Error, fmt.Sprintf("whoami error: %v", err))
+		f.reason = reasonLDAPError
+		return authFailed
+	}
+	authzID := res.AuthzID
+	f.callbacks.Log(api.Debug, fmt.Sprintf("authorization identity of %s: %q", user.dn, authzID))
+
+	switch {
+	case len(authzID) >= 3 && strings.EqualFold(authzID[:3], "dn:"):
+		dn := authzID[3:]
+		if boundDN != "" && equalDN(dn, boundDN) {
+			user.identity = dn
+			return authSucceeded
+		}
+		if boundDN != "" && conf.rejectIdentityMismatch {
+			f.callbacks.Log(api.Warn, fmt.Sprintf("authorization identity %s differs from the bound DN %s", dn, boundDN))
+			f.reason = reasonIdentityMismatch
+			return authDenied
+		}
+		// the entry of the bound name may not be the entry of the identity
+		user.dn, user.identity, user.entry = dn, dn, nil
+	case len(authzID) >= 2 && strings.EqualFold(authzID[:2], "u:"):
+		if boundDN != "" && conf.rejectIdentityMismatch {
+			f.callbacks.Log(api.Warn, fmt.Sprintf("authorization identity %s is not the bound DN %s", authzID, boundDN))
+			f.reason = reasonIdentityMismatch
+			return authDenied
+		}
+		user.identity = authzID[2:]
+		return authSucceeded
+	default:
+		// an anonymous identity means the bind didn't authenticate the user
+		f.callbacks.Log(api.Warn, fmt.Sprintf("unexpected authorization identity %q of %s", authzID, user.dn))
+		f.reason = reasonIdentityMismatch
+		return authDenied
+	}
+
+	if len(conf.requiredAttributes()) == 0 {
+		return authSucceeded
+	}
+	user.entry, err = f.lookupEntry(client, conf, user.dn)
+	if err != nil {
+		f.callbacks.Log(api.Error, fmt.Sprintf("user lookup error: %v", err))
+		return authFailed
+	}
+	if !f.checkAccountState(conf, user.entry) {
+		return authDenied
+	}
+	return authSucceeded
+}